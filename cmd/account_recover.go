@@ -4,13 +4,10 @@ package cmd
 
 import (
 	"crypto/rand"
-	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"os"
 	"strconv"
-	"strings"
 
 	"github.com/joinself/self-go-sdk/pkg/ntp"
 	"github.com/joinself/self-go-sdk/pkg/siggraph"
@@ -26,41 +23,32 @@ var accountRecoverCommand = &cobra.Command{
 			check(errors.New("you must specify an app identity and device [appID]"))
 		}
 
-		if recoveryKey == "" {
-			check(errors.New("You must provide a secret recovery key"))
-		}
-
-		if strings.Contains(recoveryKey, "_") {
-			keyParts := strings.Split(recoveryKey, "_")
-			if keyParts[0] != "rk_" {
-				check(errors.New("the recovery key provided is not valid, it should start with 'rk'"))
-			}
-			recoveryKey = keyParts[1]
-		}
+		rk := mustSecretKey(recoveryKey, keyTypeRecovery, "secret recovery key")
 
-		var edpk, edsk, erpk, ersk string
+		var edpk, erpk string
+		var dseed, rseed []byte
 
 		if devicePublicKey != "" {
-			edpk = devicePublicKey
+			edpk = mustPublicKey(devicePublicKey, "device public key")
 		} else {
 			dpk, dsk, err := ed25519.GenerateKey(rand.Reader)
 			check(err)
 
 			edpk = enc.EncodeToString(dpk)
-			edsk = base64.RawStdEncoding.EncodeToString(dsk.Seed())
+			dseed = dsk.Seed()
 		}
 
 		if recoveryPublicKey != "" {
-			erpk = recoveryPublicKey
+			erpk = mustPublicKey(recoveryPublicKey, "recovery public key")
 		} else {
 			rpk, rsk, err := ed25519.GenerateKey(rand.Reader)
 			check(err)
 
 			erpk = enc.EncodeToString(rpk)
-			ersk = base64.RawStdEncoding.EncodeToString(rsk.Seed())
+			rseed = rsk.Seed()
 		}
 
-		client := rest(args[0], recoveryKey)
+		client := rest(cmd.Context(), args[0], rk)
 
 		done := make(chan error)
 
@@ -71,7 +59,7 @@ var accountRecoverCommand = &cobra.Command{
 		done <- err
 
 		if err != nil {
-			os.Exit(1)
+			exit(1)
 		}
 
 		// load the signature graph
@@ -80,7 +68,7 @@ var accountRecoverCommand = &cobra.Command{
 		err = json.Unmarshal(resp, &history)
 		check(err)
 
-		sg, err := siggraph.New(history)
+		sg, err := loadGraph(args[0], history)
 		check(err)
 
 		// create a new operation
@@ -95,7 +83,7 @@ var accountRecoverCommand = &cobra.Command{
 
 		actions := []siggraph.Action{
 			{
-				KID:           strings.Split(recoveryKey, ":")[0],
+				KID:           rk.kid,
 				Type:          siggraph.TypeRecoveryKey,
 				Action:        siggraph.ActionKeyRevoke,
 				EffectiveFrom: int64(effectiveFrom),
@@ -117,10 +105,10 @@ var accountRecoverCommand = &cobra.Command{
 			},
 		}
 
-		operation := newOperation(sg, actions, recoveryKey)
+		operation := newOperation(sg, actions, rk)
 
 		// check the operation is valid
-		err = sg.Execute(operation)
+		err = executeOperation(args[0], sg, operation)
 		check(err)
 
 		// creating a new device
@@ -129,19 +117,29 @@ var accountRecoverCommand = &cobra.Command{
 		resp, err = client.Post("/v1/identities/"+args[0]+"/history", "application/json", operation)
 		done <- err
 
+		// the keys may have been added even if the request failed, i.e. if
+		// it timed out or was interrupted, so their private keys are kept
 		if err != nil {
-			os.Exit(1)
+			spinners.Wait()
+			errorf("\nthe new keys may have been added, keep their private keys until you have checked the identity's history\n")
+		} else {
+			progressf("\n")
 		}
 
-		fmt.Println("")
-		if edsk != "" {
-			fmt.Println("device private key:    ", dkid+":"+edsk)
+		if dseed != nil {
+			dk := newKey(keyTypeSecret, dkid, dseed)
+			fmt.Println("device private key:    ", dk)
+			fmt.Println("device sdk secret:     ", dk.Legacy())
 			fmt.Println("device public key:     ", edpk)
 		}
-		if ersk != "" {
-			fmt.Println("recovery private key:  ", rkid+":"+ersk)
+		if rseed != nil {
+			fmt.Println("recovery private key:  ", newKey(keyTypeRecovery, rkid, rseed))
 			fmt.Println("recovery public key:   ", erpk)
 		}
+
+		if err != nil {
+			exit(1)
+		}
 	},
 }
 
@@ -0,0 +1,132 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/joinself/self-go-sdk/pkg/transport"
+	"github.com/spf13/cobra"
+)
+
+var dataFile string
+
+var apiCommand = &cobra.Command{
+	Use:   "api [METHOD] [path]",
+	Short: "sends an authenticated request to the api",
+	Long:  "sends a request to any path of the api, signed as the app identity, and prints the response. A request body can be sent with POST and PUT requests from a file, or from stdin if the file is '-'",
+	Example: `  self-cli api GET /v1/identities/[selfID]/devices --app-id [appID] --secret-key MY-SECRET-DEVICE-KEY
+  self-cli api POST /v1/apps/[appID]/devices --data device.json --app-id [appID] --secret-key MY-SECRET-DEVICE-KEY`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) < 2 {
+			check(errors.New("you must specify a method and path [METHOD] [path]"))
+		}
+
+		method, path, data, err := apiRequest(args[0], args[1], dataFile)
+		check(err)
+
+		if appID == "" {
+			check(errors.New("you must specify an app identity to authenticate as [--app-id]"))
+		}
+
+		sk := mustSecretKey(secretKey, keyTypeSecret, "secret key")
+
+		client := rest(cmd.Context(), appID, sk)
+
+		done := make(chan error)
+
+		go log(method+" "+path, done)
+
+		resp, err := sendAPIRequest(client, method, path, data)
+
+		done <- err
+
+		if err != nil {
+			exit(1)
+		}
+
+		os.Stdout.Write(formatAPIResponse(resp))
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(apiCommand)
+	apiCommand.Flags().StringVarP(&secretKey, "secret-key", "s", "", "Device secret key")
+	apiCommand.Flags().StringVarP(&appID, "app-id", "a", "", "App identity to authenticate as")
+	apiCommand.Flags().StringVarP(&dataFile, "data", "d", "", "File containing the json request body, or '-' for stdin")
+}
+
+// apiRequest validates the method and path of a request, reading
+// its body from the data file if one is given
+func apiRequest(method, path, dataFile string) (string, string, []byte, error) {
+	method = strings.ToUpper(method)
+
+	switch method {
+	case http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete:
+	default:
+		return "", "", nil, errors.New("method must be one of [GET, POST, PUT, DELETE]")
+	}
+
+	if !strings.HasPrefix(path, "/") {
+		return "", "", nil, errors.New("the path must start with '/', i.e. '/v1/identities/[selfID]'")
+	}
+
+	if dataFile == "" {
+		return method, path, nil, nil
+	}
+
+	if method != http.MethodPost && method != http.MethodPut {
+		return "", "", nil, errors.New("a request body can only be sent with POST and PUT requests")
+	}
+
+	data, err := readInput(dataFile)
+	if err != nil {
+		return "", "", nil, err
+	}
+
+	if !json.Valid(data) {
+		return "", "", nil, errors.New("the request body must be valid json")
+	}
+
+	return method, path, data, nil
+}
+
+// sendAPIRequest sends a request with the client, returning the response body
+func sendAPIRequest(client *transport.Rest, method, path string, data []byte) ([]byte, error) {
+	switch method {
+	case http.MethodGet:
+		return client.Get(path)
+	case http.MethodPost:
+		return client.Post(path, "application/json", data)
+	case http.MethodPut:
+		return client.Put(path, "application/json", data)
+	case http.MethodDelete:
+		return client.Delete(path)
+	}
+
+	return nil, fmt.Errorf("unsupported method %s", method)
+}
+
+// formatAPIResponse indents a json response body. Bodies
+// that are not json are returned as they were received
+func formatAPIResponse(resp []byte) []byte {
+	if len(bytes.TrimSpace(resp)) < 1 {
+		return nil
+	}
+
+	var out bytes.Buffer
+
+	if json.Indent(&out, resp, "", "  ") != nil {
+		return resp
+	}
+
+	out.WriteString("\n")
+
+	return out.Bytes()
+}
@@ -0,0 +1,146 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package cmd
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAPIRequest(t *testing.T) {
+	dir := t.TempDir()
+
+	body := filepath.Join(dir, "device.json")
+	invalid := filepath.Join(dir, "invalid.json")
+
+	err := os.WriteFile(body, []byte(`{"id":"device"}`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.WriteFile(invalid, []byte(`{"id":`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		dataFile string
+		expected string
+		data     string
+		err      bool
+	}{
+		{name: "get", method: "GET", path: "/v1/identities/app", expected: http.MethodGet},
+		{name: "lower case method", method: "delete", path: "/v1/apps/app/devices/1", expected: http.MethodDelete},
+		{name: "post with body", method: "post", path: "/v1/apps/app/devices", dataFile: body, expected: http.MethodPost, data: `{"id":"device"}`},
+		{name: "put with body", method: "PUT", path: "/v1/apps/app/devices/1", dataFile: body, expected: http.MethodPut, data: `{"id":"device"}`},
+		{name: "unsupported method", method: "PATCH", path: "/v1/apps/app", err: true},
+		{name: "relative path", method: "GET", path: "v1/identities/app", err: true},
+		{name: "get with body", method: "GET", path: "/v1/identities/app", dataFile: body, err: true},
+		{name: "body is not json", method: "POST", path: "/v1/apps/app/devices", dataFile: invalid, err: true},
+		{name: "missing body", method: "POST", path: "/v1/apps/app/devices", dataFile: filepath.Join(dir, "missing.json"), err: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			method, path, data, err := apiRequest(tc.method, tc.path, tc.dataFile)
+			if tc.err {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if method != tc.expected || path != tc.path || string(data) != tc.data {
+				t.Fatalf("expected %s %s %s, got %s %s %s", tc.expected, tc.path, tc.data, method, path, data)
+			}
+		})
+	}
+}
+
+func TestSendAPIRequest(t *testing.T) {
+	tests := []struct {
+		method string
+		data   string
+	}{
+		{http.MethodGet, ""},
+		{http.MethodPost, `{"id":"device"}`},
+		{http.MethodPut, `{"id":"device"}`},
+		{http.MethodDelete, ""},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.method, func(t *testing.T) {
+			var received *http.Request
+			var body []byte
+
+			client := testRest(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				received = r
+				body, _ = io.ReadAll(r.Body)
+
+				w.Write([]byte(`{"ok":true}`))
+			}))
+
+			var data []byte
+			if tc.data != "" {
+				data = []byte(tc.data)
+			}
+
+			resp, err := sendAPIRequest(client, tc.method, "/v1/apps/app/devices", data)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if string(resp) != `{"ok":true}` {
+				t.Fatalf("expected the response body, got %s", resp)
+			}
+
+			if received.Method != tc.method || received.URL.Path != "/v1/apps/app/devices" {
+				t.Fatalf("expected %s /v1/apps/app/devices, got %s %s", tc.method, received.Method, received.URL.Path)
+			}
+
+			if tokenKID(received) != "1" {
+				t.Fatalf("expected the request to be authenticated with key 1, got '%s'", received.Header.Get("Authorization"))
+			}
+
+			if string(body) != tc.data {
+				t.Fatalf("expected body '%s', got '%s'", tc.data, body)
+			}
+
+			if tc.data != "" && received.Header.Get("Content-Type") != "application/json" {
+				t.Fatalf("expected a json content type, got '%s'", received.Header.Get("Content-Type"))
+			}
+		})
+	}
+}
+
+func TestFormatAPIResponse(t *testing.T) {
+	tests := []struct {
+		name     string
+		resp     string
+		expected string
+	}{
+		{"json", `{"id":"app","devices":["1"]}`, "{\n  \"id\": \"app\",\n  \"devices\": [\n    \"1\"\n  ]\n}\n"},
+		{"not json", "accepted", "accepted"},
+		{"empty", " \n", ""},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			out := formatAPIResponse([]byte(tc.resp))
+
+			if string(out) != tc.expected {
+				t.Fatalf("expected %q, got %q", tc.expected, out)
+			}
+		})
+	}
+}
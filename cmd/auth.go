@@ -0,0 +1,21 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+var authCommand = &cobra.Command{
+	Use:   "auth",
+	Short: "the auth command",
+	Run: func(cmd *cobra.Command, args []string) {
+		fmt.Println("auth called")
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(authCommand)
+}
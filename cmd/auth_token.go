@@ -0,0 +1,73 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/joinself/self-go-sdk/pkg/ntp"
+	"github.com/spf13/cobra"
+)
+
+var tokenTTL time.Duration
+
+var authTokenCommand = &cobra.Command{
+	Use:     "token [appID]",
+	Short:   "outputs a bearer token for authenticating to the api",
+	Long:    "outputs a bearer token signed with a device key, the same as is used by the sdk to authenticate requests to the api. The token can be used to make requests with other tools, i.e. curl",
+	Example: `  curl -H "Authorization: Bearer $(self-cli auth token --key MY-SECRET-DEVICE-KEY [appID])" https://api.joinself.com/v1/identities/[appID]`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) < 1 {
+			check(errors.New("you must specify an app identity [appID]"))
+		}
+
+		sk := mustSecretKey(secretKey, keyTypeSecret, "secret key")
+
+		token, err := authToken(args[0], sk, tokenTTL)
+		check(err)
+
+		fmt.Println(token)
+	},
+}
+
+func init() {
+	authCommand.AddCommand(authTokenCommand)
+	authTokenCommand.Flags().StringVarP(&secretKey, "key", "k", "", "Device secret key to sign the token with")
+	authTokenCommand.Flags().DurationVarP(&tokenTTL, "ttl", "t", time.Minute, "Time until the token expires")
+}
+
+// authToken generates a compact jws bearer token for an identity, with the same
+// claims as the tokens generated by the sdk, but with a configurable expiry
+func authToken(selfID string, sk *key, ttl time.Duration) (string, error) {
+	if ttl < time.Second {
+		return "", errors.New("the token's time to live must be at least 1s")
+	}
+
+	now := ntp.TimeFunc()
+
+	claims, err := json.Marshal(map[string]interface{}{
+		"jti": uuid.New().String(),
+		"cid": uuid.New().String(),
+		"typ": "auth.token",
+		"iss": selfID,
+		"sub": selfID,
+		// allow for clock skew between the cli and the api
+		"iat": now.Add(-5 * time.Second).Unix(),
+		"exp": now.Add(ttl).Unix(),
+	})
+
+	if err != nil {
+		return "", err
+	}
+
+	jws, err := signJWS(sk, claims)
+	if err != nil {
+		return "", err
+	}
+
+	return jws.CompactSerialize()
+}
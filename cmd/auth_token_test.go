@@ -0,0 +1,103 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package cmd
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/joinself/self-go-sdk/pkg/ntp"
+	"github.com/square/go-jose"
+)
+
+func TestAuthToken(t *testing.T) {
+	sk := generateKey(keyTypeSecret, "3")
+
+	now := time.Unix(1600000000, 0)
+
+	timeFunc := ntp.TimeFunc
+	defer func() { ntp.TimeFunc = timeFunc }()
+
+	ntp.TimeFunc = func() time.Time { return now }
+
+	tests := []struct {
+		name string
+		ttl  time.Duration
+		exp  int64
+		err  bool
+	}{
+		{"default", time.Minute, 1600000060, false},
+		{"long lived", 24 * time.Hour, 1600086400, false},
+		{"minimum", time.Second, 1600000001, false},
+		{"too short", 500 * time.Millisecond, 0, true},
+	}
+
+	var ids []string
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			token, err := authToken("app", sk, tc.ttl)
+			if tc.err {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			jws, err := jose.ParseSigned(token)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(jws.Signatures) != 1 || jws.Signatures[0].Header.KeyID != "3" || jws.Signatures[0].Header.Algorithm != string(jose.EdDSA) {
+				t.Fatal("expected the token to be signed with key 3")
+			}
+
+			payload, err := jws.Verify(sk.publicKey())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var claims struct {
+				JTI string `json:"jti"`
+				CID string `json:"cid"`
+				Typ string `json:"typ"`
+				Iss string `json:"iss"`
+				Sub string `json:"sub"`
+				Iat int64  `json:"iat"`
+				Exp int64  `json:"exp"`
+			}
+
+			err = json.Unmarshal(payload, &claims)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if claims.Typ != "auth.token" || claims.Iss != "app" || claims.Sub != "app" {
+				t.Fatalf("expected an auth token for app, got %s", payload)
+			}
+
+			// issued in the past, to allow for clock skew with the api
+			if claims.Iat != 1599999995 || claims.Exp != tc.exp {
+				t.Fatalf("expected the token to be valid from 1599999995 until %d, got %d until %d", tc.exp, claims.Iat, claims.Exp)
+			}
+
+			if claims.JTI == "" || claims.CID == "" {
+				t.Fatalf("expected the token to have an id, got %s", payload)
+			}
+
+			for _, id := range ids {
+				if id == claims.JTI {
+					t.Fatal("expected each token to have a unique id")
+				}
+			}
+
+			ids = append(ids, claims.JTI)
+		})
+	}
+}
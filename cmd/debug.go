@@ -0,0 +1,242 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	redacted = "[REDACTED]"
+
+	// maxDebugBody the maximum number of bytes of a body that are logged
+	maxDebugBody = 64 * 1024
+)
+
+var (
+	debug   bool
+	debugMu sync.Mutex
+
+	// sensitiveHeaders headers whose values are always redacted
+	sensitiveHeaders = map[string]bool{
+		"Authorization":       true,
+		"Proxy-Authorization": true,
+		"Cookie":              true,
+		"Set-Cookie":          true,
+	}
+
+	// sensitiveFields json fields and query parameters whose values are always redacted
+	sensitiveFields = map[string]bool{
+		"token":         true,
+		"access_token":  true,
+		"refresh_token": true,
+		"secret":        true,
+		"secret_key":    true,
+		"private_key":   true,
+		"password":      true,
+		"seed":          true,
+	}
+
+	// sensitivePatterns secret keys, legacy sdk secrets and compact jwts
+	sensitivePatterns = []*regexp.Regexp{
+		regexp.MustCompile(`\b(sk|rk)_[A-Za-z0-9_-]+:[A-Za-z0-9_-]{43,}`),
+		regexp.MustCompile(`\b[0-9]+:[A-Za-z0-9+/]{43}\b`),
+		regexp.MustCompile(`\beyJ[A-Za-z0-9_-]*\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+`),
+	}
+)
+
+// debugTransport logs every request and response to stderr, redacting any
+// credentials, private key material and tokens
+type debugTransport struct {
+	next http.RoundTripper
+}
+
+func init() {
+	rootCmd.PersistentFlags().BoolVar(&debug, "debug", false, "Log every request made to the api and its response, with any secrets redacted")
+}
+
+// RoundTrip sends a request, logging it and its response
+func (t *debugTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+
+	if req.Body != nil {
+		var err error
+
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+
+		if err != nil {
+			return nil, err
+		}
+
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "--> %s %s\n", req.Method, redactURL(req))
+	writeDebugHeaders(&b, req.Header)
+	writeDebugBody(&b, body)
+
+	debugLog(b.String())
+
+	start := time.Now()
+
+	resp, err := t.next.RoundTrip(req)
+
+	b.Reset()
+
+	if err != nil {
+		fmt.Fprintf(&b, "<-- %s %s failed after %s: %s\n", req.Method, req.URL.Path, time.Since(start).Round(time.Millisecond), redact(err.Error()))
+		debugLog(b.String())
+
+		return nil, err
+	}
+
+	body, rerr := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	fmt.Fprintf(&b, "<-- %s %s %s (%s)\n", req.Method, req.URL.Path, resp.Status, time.Since(start).Round(time.Millisecond))
+	writeDebugHeaders(&b, resp.Header)
+	writeDebugBody(&b, body)
+
+	debugLog(b.String())
+
+	if rerr != nil {
+		return nil, rerr
+	}
+
+	return resp, nil
+}
+
+// debugLog writes a log entry to stderr
+func debugLog(entry string) {
+	debugMu.Lock()
+	defer debugMu.Unlock()
+
+	fmt.Fprintln(os.Stderr, entry)
+}
+
+// redactURL returns a requests url, with any sensitive query parameters redacted
+func redactURL(req *http.Request) string {
+	u := *req.URL
+
+	q := u.Query()
+
+	for name := range q {
+		if sensitiveFields[strings.ToLower(name)] {
+			q.Set(name, redacted)
+		}
+	}
+
+	u.RawQuery = q.Encode()
+	u.User = nil
+
+	return redact(u.String())
+}
+
+func writeDebugHeaders(b *strings.Builder, header http.Header) {
+	var names []string
+
+	for name := range header {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		value := strings.Join(header[name], ", ")
+
+		if sensitiveHeaders[http.CanonicalHeaderKey(name)] {
+			// keep the scheme, i.e. 'Bearer'
+			scheme := strings.SplitN(value, " ", 2)
+			value = redacted
+
+			if len(scheme) > 1 {
+				value = scheme[0] + " " + redacted
+			}
+		}
+
+		fmt.Fprintf(b, "    %s: %s\n", name, redact(value))
+	}
+}
+
+func writeDebugBody(b *strings.Builder, body []byte) {
+	if len(body) < 1 {
+		return
+	}
+
+	truncated := len(body) > maxDebugBody
+	if truncated {
+		body = body[:maxDebugBody]
+	}
+
+	fmt.Fprintf(b, "\n    %s\n", strings.ReplaceAll(redactBody(body), "\n", "\n    "))
+
+	if truncated {
+		fmt.Fprintf(b, "    ... truncated to %d bytes\n", maxDebugBody)
+	}
+}
+
+// redactBody redacts sensitive fields from a json body, and any
+// secret keys or tokens from other bodies
+func redactBody(body []byte) string {
+	var v interface{}
+
+	if json.Unmarshal(body, &v) != nil {
+		return redact(string(body))
+	}
+
+	data, err := json.Marshal(redactJSON(v))
+	if err != nil {
+		return redact(string(body))
+	}
+
+	return string(data)
+}
+
+// redactJSON redacts the values of sensitive fields from a decoded json value
+func redactJSON(v interface{}) interface{} {
+	switch value := v.(type) {
+	case map[string]interface{}:
+		// the private key of a json web key
+		_, jwk := value["kty"]
+
+		for k, fv := range value {
+			if sensitiveFields[strings.ToLower(k)] || jwk && k == "d" {
+				value[k] = redacted
+				continue
+			}
+
+			value[k] = redactJSON(fv)
+		}
+	case []interface{}:
+		for i := range value {
+			value[i] = redactJSON(value[i])
+		}
+	case string:
+		return redact(value)
+	}
+
+	return v
+}
+
+// redact replaces any secret keys or tokens in a string
+func redact(s string) string {
+	for _, p := range sensitivePatterns {
+		s = p.ReplaceAllString(s, redacted)
+	}
+
+	return s
+}
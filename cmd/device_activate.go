@@ -4,8 +4,8 @@ package cmd
 
 import (
 	"errors"
-	"os"
 
+	"github.com/joinself/self-go-sdk/pkg/transport"
 	"github.com/spf13/cobra"
 )
 
@@ -18,23 +18,19 @@ var deviceActivateCommand = &cobra.Command{
 			check(errors.New("you must specify an app identity and device [appID, deviceID]"))
 		}
 
-		if secretKey == "" {
-			check(errors.New("You must provide a secret key"))
-		}
+		sk := mustSecretKey(secretKey, keyTypeSecret, "secret key")
 
-		client := rest(args[0], secretKey)
+		client := rest(cmd.Context(), args[0], sk)
 
 		done := make(chan error)
 
 		go log("advertising new device", done)
 
-		device := []byte(`{"id": "` + args[1] + `", "platform": "sdk", "token": "-"}`)
-
-		_, err := client.Post("/v1/identities/"+args[0]+"/devices", "application/json", device)
+		err := activateDevice(client, args[0], args[1])
 		done <- err
 
 		if err != nil {
-			os.Exit(1)
+			exit(1)
 		}
 	},
 }
@@ -43,3 +39,12 @@ func init() {
 	deviceCommand.AddCommand(deviceActivateCommand)
 	deviceActivateCommand.Flags().StringVarP(&secretKey, "secret-key", "s", "", "Device secret key")
 }
+
+// activateDevice advertises a device as available for receiving messages
+func activateDevice(client *transport.Rest, selfID, deviceID string) error {
+	device := []byte(`{"id": "` + deviceID + `", "platform": "sdk", "token": "-"}`)
+
+	_, err := client.Post("/v1/identities/"+selfID+"/devices", "application/json", device)
+
+	return err
+}
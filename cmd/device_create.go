@@ -4,11 +4,9 @@ package cmd
 
 import (
 	"crypto/rand"
-	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"os"
 	"strconv"
 
 	"github.com/joinself/self-go-sdk/pkg/ntp"
@@ -25,24 +23,22 @@ var deviceCreateCommand = &cobra.Command{
 			check(errors.New("you must specify an app identity [appID]"))
 		}
 
-		if secretKey == "" {
-			check(errors.New("You must provide a secret key"))
-		}
+		sk := mustSecretKey(secretKey, keyTypeSecret, "secret key")
 
 		var epk string
-		var esk string
+		var seed []byte
 
 		if devicePublicKey == "" {
-			pk, sk, err := ed25519.GenerateKey(rand.Reader)
+			dpk, dsk, err := ed25519.GenerateKey(rand.Reader)
 			check(err)
 
-			epk = enc.EncodeToString(pk)
-			esk = base64.RawStdEncoding.EncodeToString(sk.Seed())
+			epk = enc.EncodeToString(dpk)
+			seed = dsk.Seed()
 		} else {
-			epk = devicePublicKey
+			epk = mustPublicKey(devicePublicKey, "device public key")
 		}
 
-		client := rest(args[0], secretKey)
+		client := rest(cmd.Context(), args[0], sk)
 
 		done := make(chan error)
 
@@ -53,7 +49,7 @@ var deviceCreateCommand = &cobra.Command{
 		done <- err
 
 		if err != nil {
-			os.Exit(1)
+			exit(1)
 		}
 
 		// load the signature graph
@@ -62,7 +58,7 @@ var deviceCreateCommand = &cobra.Command{
 		err = json.Unmarshal(resp, &app)
 		check(err)
 
-		sg, err := siggraph.New(app.History)
+		sg, err := loadGraph(args[0], app.History)
 		check(err)
 
 		// create a new operation
@@ -80,10 +76,10 @@ var deviceCreateCommand = &cobra.Command{
 			},
 		}
 
-		operation := newOperation(sg, actions, secretKey)
+		operation := newOperation(sg, actions, sk)
 
 		// check the operation is valid
-		err = sg.Execute(operation)
+		err = executeOperation(args[0], sg, operation)
 		check(err)
 
 		// creating a new device
@@ -92,6 +88,21 @@ var deviceCreateCommand = &cobra.Command{
 		resp, err = client.Post("/v1/identities/"+args[0]+"/history", "application/json", operation)
 		done <- err
 
+		if err != nil {
+			// the key may have been added even if the request failed, i.e. if
+			// it timed out or was interrupted, so its private key is kept
+			if seed != nil {
+				dk := newKey(keyTypeSecret, kid, seed)
+
+				spinners.Wait()
+				errorf("\nthe device key may have been added, keep its private key until you have checked the identity's history\n")
+				fmt.Println("  device private key:  ", dk)
+				fmt.Println("  device sdk secret:   ", dk.Legacy())
+			}
+
+			exit(1)
+		}
+
 		device := []byte(`{"id": "` + did + `", "platform": "sdk", "token": "-"}`)
 
 		go log("activating new device", done)
@@ -99,15 +110,24 @@ var deviceCreateCommand = &cobra.Command{
 		resp, err = client.Post("/v1/identities/"+args[0]+"/devices", "application/json", device)
 		done <- err
 
-		if esk != "" {
-			fmt.Println("")
-			fmt.Printf("successfully created device '%s'\n", did)
-			fmt.Println("  device private key:  ", kid+":"+esk)
-			fmt.Println("  device public key:   ", epk)
+		progressf("\n")
+		fmt.Printf("successfully created device '%s'\n", did)
+
+		if seed != nil {
+			dk := newKey(keyTypeSecret, kid, seed)
+
+			fmt.Println("  device private key:  ", dk)
+			fmt.Println("  device sdk secret:   ", dk.Legacy())
+		} else {
+			// the key was generated elsewhere, so the kid it has been
+			// assigned is needed to encode its secret key
+			fmt.Println("  device key id:       ", kid)
 		}
 
+		fmt.Println("  device public key:   ", epk)
+
 		if err != nil {
-			os.Exit(1)
+			exit(1)
 		}
 	},
 }
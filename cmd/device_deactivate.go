@@ -4,8 +4,8 @@ package cmd
 
 import (
 	"errors"
-	"os"
 
+	"github.com/joinself/self-go-sdk/pkg/transport"
 	"github.com/spf13/cobra"
 )
 
@@ -18,21 +18,19 @@ var deviceDeactivateCommand = &cobra.Command{
 			check(errors.New("you must specify an app identity and device [appID, deviceID]"))
 		}
 
-		if secretKey == "" {
-			check(errors.New("You must provide a secret key"))
-		}
+		sk := mustSecretKey(secretKey, keyTypeSecret, "secret key")
 
-		client := rest(args[0], secretKey)
+		client := rest(cmd.Context(), args[0], sk)
 
 		done := make(chan error)
 
 		go log("deactivating new device", done)
 
-		_, err := client.Delete("/v1/identities/" + args[0] + "/devices/" + args[1])
+		err := deactivateDevice(client, args[0], args[1])
 		done <- err
 
 		if err != nil {
-			os.Exit(1)
+			exit(1)
 		}
 	},
 }
@@ -41,3 +39,10 @@ func init() {
 	deviceCommand.AddCommand(deviceDeactivateCommand)
 	deviceDeactivateCommand.Flags().StringVarP(&secretKey, "secret-key", "s", "", "Device secret key")
 }
+
+// deactivateDevice marks a device as unavailable for receiving messages
+func deactivateDevice(client *transport.Rest, selfID, deviceID string) error {
+	_, err := client.Delete("/v1/identities/" + selfID + "/devices/" + deviceID)
+
+	return err
+}
@@ -3,12 +3,10 @@
 package cmd
 
 import (
-	"encoding/json"
+	"context"
 	"errors"
 	"fmt"
 	"os"
-	"sort"
-	"strconv"
 	"time"
 
 	"github.com/joinself/self-go-sdk/pkg/siggraph"
@@ -19,135 +17,195 @@ import (
 var deviceListCommand = &cobra.Command{
 	Use:   "list",
 	Short: "lists all devices",
+	Long:  "lists the device keys of an identity, and whether their devices are advertised as active. If an inventory is specified, the devices of every identity in the inventory are listed",
 	Run: func(cmd *cobra.Command, args []string) {
+		if inventoryFile != "" {
+			listInventoryDevices(cmd.Context(), mustLoadInventory())
+			return
+		}
+
 		if len(args) < 1 {
 			check(errors.New("you must specify an app identity [appID]"))
 		}
 
-		if secretKey == "" {
-			check(errors.New("You must provide a secret key"))
-		}
+		sk := mustSecretKey(secretKey, keyTypeSecret, "secret key")
+
+		client := rest(cmd.Context(), args[0], sk)
 
-		client := rest(args[0], secretKey)
+		app, sg := getIdentity(client, args[0])
 
 		done := make(chan error)
 
-		// get the identity history
-		go log("getting identity history", done)
+		// get the devices
+		go log("getting devices", done)
 
-		resp, err := client.Get("/v1/identities/" + args[0])
+		devices, err := fetchDevices(client, args[0])
 		done <- err
 
 		if err != nil {
-			os.Exit(1)
+			exit(1)
 		}
 
-		// load the signature graph
-		var app Identity
-
-		err = json.Unmarshal(resp, &app)
+		history, sg, at, err := pointInTime(app.History, sg)
 		check(err)
 
-		sg, err := siggraph.New(app.History)
+		keys, err := keyHistory(history, sg)
 		check(err)
 
-		// get the devices
-		go log("getting devices", done)
+		header := []string{"KID", "DID", "ACTIVE", "REVOKED"}
 
-		resp, err = client.Get("/v1/identities/" + args[0] + "/devices")
-		done <- err
+		if timeTravel() {
+			header = []string{"KID", "DID", "REVOKED", "STATE"}
 
-		if err != nil {
-			os.Exit(1)
+			fmt.Println("")
+			fmt.Printf("key state at %s (sequence %d)\n", at.Format(time.RFC3339), sg.NextSequence()-1)
 		}
 
-		var deviceArray []string
+		printDevices(header, deviceLines(keys, devices, at))
+	},
+}
 
-		err = json.Unmarshal(resp, &deviceArray)
-		check(err)
+func init() {
+	deviceCommand.AddCommand(deviceListCommand)
+	deviceListCommand.Flags().StringVarP(&secretKey, "secret-key", "s", "", "Device secret key")
+	deviceListCommand.Flags().StringVarP(&atTime, "at", "t", "", "Show the state of keys at a point in time [unix timestamp, RFC3339]")
+	deviceListCommand.Flags().IntVarP(&atSequence, "at-sequence", "q", -1, "Show the state of keys as of an operation in the identities history")
+	deviceListCommand.Flags().StringVarP(&inventoryFile, "identities", "I", "", "Inventory file of identities to list devices for")
+	deviceListCommand.Flags().IntVarP(&concurrency, "concurrency", "c", 4, "Number of identities to list devices for concurrently")
+}
 
-		devices := make(map[string]struct{})
+// listInventoryDevices lists the devices of every identity in an inventory
+func listInventoryDevices(ctx context.Context, inv *inventory) {
+	done := make(chan error)
 
-		for _, d := range deviceArray {
-			devices[d] = struct{}{}
-		}
+	go log(fmt.Sprintf("getting devices for %d identities", len(inv.Identities)), done)
 
-		kl := deviceList(sg.Keys())
-		sort.Sort(kl)
+	results := forEachIdentity(ctx, inv, func(i inventoryIdentity, sk *key) (interface{}, error) {
+		client, err := newRest(ctx, i.SelfID, sk)
+		if err != nil {
+			return nil, err
+		}
 
-		lines := make([][]string, len(kl))
+		identity, sg, err := fetchIdentity(client, i.SelfID)
+		if err != nil {
+			return nil, err
+		}
 
-		for i, k := range kl {
-			if k == "" {
-				continue
-			}
+		devices, err := fetchDevices(client, i.SelfID)
+		if err != nil {
+			return nil, err
+		}
 
-			did, err := sg.GetDeviceID(k)
-			if err != nil {
-				if err == siggraph.ErrNotDeviceKey {
-					continue
-				}
-				check(err)
-			}
+		history, sg, at, err := pointInTime(identity.History, sg)
+		if err != nil {
+			return nil, err
+		}
 
-			_, active := devices[did]
+		keys, err := keyHistory(history, sg)
+		if err != nil {
+			return nil, err
+		}
 
-			ra, err := sg.RevokedAt(k)
-			check(err)
+		return deviceLines(keys, devices, at), nil
+	})
 
-			lines[i] = []string{k, did}
+	done <- nil
 
-			if active {
-				lines[i] = append(lines[i], "\033[1;32m✓\033[0m")
-			} else {
-				lines[i] = append(lines[i], "\033[1;31m✘\033[0m")
-			}
+	var lines [][]string
 
-			if ra == 0 {
-				lines[i] = append(lines[i], "\033[1;34m-\033[0m")
-			} else {
-				lines[i] = append(lines[i], fmt.Sprintf("\033[1;31m%s\033[0m", time.Unix(ra, 0).Format(time.RFC3339)))
-			}
+	for _, r := range results {
+		if r.Err != nil {
+			continue
+		}
 
+		for _, line := range r.Value.([][]string) {
+			lines = append(lines, append([]string{r.SelfID}, line...))
 		}
+	}
 
-		fmt.Println("")
+	header := []string{"SELF ID", "KID", "DID", "ACTIVE", "REVOKED"}
 
-		table := tablewriter.NewWriter(os.Stdout)
-		table.SetHeader([]string{"KID", "DID", "ACTIVE", "REVOKED"})
-		table.SetAlignment(tablewriter.ALIGN_CENTER)
-		table.SetHeaderLine(false)
-		table.SetRowLine(false)
-		table.SetBorder(false)
-		table.SetCenterSeparator("")
-		table.SetColumnSeparator("")
-		table.AppendBulk(lines)
-		table.Render()
-	},
-}
+	if timeTravel() {
+		header = []string{"SELF ID", "KID", "DID", "REVOKED", "STATE"}
+	}
 
-func init() {
-	deviceCommand.AddCommand(deviceListCommand)
-	deviceListCommand.Flags().StringVarP(&secretKey, "secret-key", "s", "", "Device secret key")
+	printDevices(header, lines)
+
+	if reportErrors(results) {
+		exit(1)
+	}
 }
 
-type deviceList []string
+// deviceLines builds the table lines for an identities device keys at a point in time.
+// Devices are only advertised as they are now, so whether a device is active is not
+// shown for other points in time
+func deviceLines(keys []*keyInfo, devices []string, at time.Time) [][]string {
+	var lines [][]string
 
-func (d deviceList) Len() int {
-	return len(d)
-}
+	for _, k := range keys {
+		if k.Type != siggraph.TypeDeviceKey {
+			continue
+		}
 
-func (d deviceList) Swap(i, j int) {
-	d[i], d[j] = d[j], d[i]
-}
+		state := k.stateAt(at)
+		if timeTravel() && state == "" {
+			// the key had not been added at this point in time
+			continue
+		}
+
+		line := []string{k.KID, k.DID}
+
+		revoked := k.RevokedAt
+
+		switch {
+		case timeTravel():
+			revoked = k.revokedAsOf(at)
+		case contains(devices, k.DID):
+			line = append(line, paint(os.Stdout, colorGreen, "✓"))
+		default:
+			line = append(line, paint(os.Stdout, colorRed, "✘"))
+		}
+
+		if revoked == 0 {
+			line = append(line, paint(os.Stdout, colorBlue, "-"))
+		} else {
+			line = append(line, paint(os.Stdout, colorRed, time.Unix(revoked, 0).Format(time.RFC3339)))
+		}
 
-func (d deviceList) Less(i, j int) bool {
-	is, ierr := strconv.Atoi(d[i])
-	js, jerr := strconv.Atoi(d[j])
+		if timeTravel() {
+			switch state {
+			case keyStateValid:
+				line = append(line, paint(os.Stdout, colorGreen, state))
+			case keyStatePending:
+				line = append(line, paint(os.Stdout, colorYellow, state))
+			default:
+				line = append(line, paint(os.Stdout, colorRed, state))
+			}
+		}
 
-	if ierr == nil && jerr == nil {
-		return is < js
+		lines = append(lines, line)
 	}
 
-	return d[i] < d[j]
+	return lines
+}
+
+func printDevices(header []string, lines [][]string) {
+	progressf("\n")
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader(header)
+	table.SetAlignment(tablewriter.ALIGN_CENTER)
+	table.SetHeaderLine(false)
+	table.SetRowLine(false)
+	table.SetBorder(false)
+	table.SetCenterSeparator("")
+	table.SetColumnSeparator("")
+	table.AppendBulk(lines)
+	table.Render()
+}
+
+// timeTravel returns true if a point in time has been
+// specified with the '--at' or '--at-sequence' flags
+func timeTravel() bool {
+	return atTime != "" || atSequence >= 0
 }
@@ -0,0 +1,73 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package cmd
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/joinself/self-go-sdk/pkg/siggraph"
+)
+
+func TestDeviceLinesPointInTime(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+
+	ti := newTestIdentity(t, "app", now.Add(-2*time.Hour), "1", "2")
+
+	revokedAt := now.Add(-30 * time.Minute)
+
+	ti.add(t, ti.keys["1"], revokedAt, siggraph.Action{
+		KID:           "2",
+		DID:           "2",
+		Type:          siggraph.TypeDeviceKey,
+		Action:        siggraph.ActionKeyRevoke,
+		EffectiveFrom: revokedAt.Unix(),
+	})
+
+	revoked := revokedAt.Format(time.RFC3339)
+
+	tests := []struct {
+		name     string
+		at       string
+		sequence int
+		expected []string
+	}{
+		{"now", "", -1, []string{"1 1 ✓ -", "2 2 ✓ " + revoked}},
+		{"before revocation", strconv.FormatInt(now.Add(-time.Hour).Unix(), 10), -1, []string{"1 1 - valid", "2 2 - valid"}},
+		{"after revocation", strconv.FormatInt(now.Unix(), 10), -1, []string{"1 1 - valid", "2 2 " + revoked + " revoked"}},
+		{"at sequence", "", 0, []string{"1 1 - valid", "2 2 - valid"}},
+		{"at sequence and time", strconv.FormatInt(now.Unix(), 10), 0, []string{"1 1 - valid", "2 2 - valid"}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			previousTime, previousSequence := atTime, atSequence
+			defer func() { atTime, atSequence = previousTime, previousSequence }()
+
+			atTime, atSequence = tc.at, tc.sequence
+
+			history, sg, at, err := pointInTime(ti.history, ti.graph(t))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			keys, err := keyHistory(history, sg)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// device 2 is still advertised, but that is only known for the current time
+			var lines []string
+
+			for _, line := range deviceLines(keys, []string{"1", "2"}, at) {
+				lines = append(lines, strings.Join(line, " "))
+			}
+
+			if strings.Join(lines, "\n") != strings.Join(tc.expected, "\n") {
+				t.Fatalf("expected lines:\n%s\ngot:\n%s", strings.Join(tc.expected, "\n"), strings.Join(lines, "\n"))
+			}
+		})
+	}
+}
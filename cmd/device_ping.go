@@ -0,0 +1,132 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/spf13/cobra"
+)
+
+var devicePingCommand = &cobra.Command{
+	Use:     "ping [appID]",
+	Short:   "sends a message between two of an identities devices",
+	Long:    "checks that messaging works end to end by sending a ping from one of an identities devices to another, and waiting for it to reply. The round trip is verified against the identity's signature graph",
+	Example: `  self-cli device ping [appID] --secret-key MY-SECRET-DEVICE-KEY --recipient-key OTHER-SECRET-DEVICE-KEY`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) < 1 {
+			check(errors.New("you must specify an app identity [appID]"))
+		}
+
+		sk := mustSecretKey(secretKey, keyTypeSecret, "secret key")
+		rk := mustSecretKey(recipientKey, keyTypeSecret, "recipient secret key")
+
+		if pingWait <= 0 {
+			check(errors.New("wait must be greater than zero"))
+		}
+
+		if sk.kid == rk.kid {
+			check(errors.New("the secret key and recipient key must belong to different devices"))
+		}
+
+		ctx := cmd.Context()
+
+		done := make(chan error)
+
+		// connect both devices to messaging
+		go log("connecting sending device", done)
+
+		sender, err := connectDevice(ctx, args[0], sk)
+		done <- err
+
+		if err != nil {
+			exit(1)
+		}
+
+		go log("connecting receiving device", done)
+
+		recipient, err := connectDevice(ctx, args[0], rk)
+		done <- err
+
+		if err != nil {
+			sender.client.Close()
+			exit(1)
+		}
+
+		closeDevices := func() {
+			sender.client.Close()
+			recipient.client.Close()
+		}
+
+		pings := make(chan *receivedMessage, 16)
+		pongs := make(chan *receivedMessage, 16)
+
+		recipient.subscribe([]string{messageTypePing}, pings)
+		sender.subscribe([]string{messageTypePong}, pongs)
+
+		cid := uuid.New().String()
+
+		// send the ping and wait for the receiving device to get it
+		go log(fmt.Sprintf("sending ping from %s to %s", sender.did, recipient.did), done)
+
+		start := time.Now()
+
+		_, err = sender.send(recipient.address(), messageTypePing, map[string]interface{}{"cid": cid})
+		if err == nil {
+			var m *receivedMessage
+
+			m, err = waitForMessage(ctx, pings, pingWait, conversation(messageTypePing, cid))
+			if err == nil && !m.Verified {
+				err = errors.New("the ping could not be verified against the identity's signature graph")
+			}
+		}
+
+		done <- err
+
+		if err != nil {
+			closeDevices()
+			exit(1)
+		}
+
+		delivered := time.Since(start)
+
+		// reply with a pong and wait for the sending device to get it
+		go log(fmt.Sprintf("sending pong from %s to %s", recipient.did, sender.did), done)
+
+		_, err = recipient.send(sender.address(), messageTypePong, map[string]interface{}{"cid": cid})
+		if err == nil {
+			var m *receivedMessage
+
+			m, err = waitForMessage(ctx, pongs, pingWait, conversation(messageTypePong, cid))
+			if err == nil && !m.Verified {
+				err = errors.New("the pong could not be verified against the identity's signature graph")
+			}
+		}
+
+		done <- err
+
+		if err != nil {
+			closeDevices()
+			exit(1)
+		}
+
+		roundTrip := time.Since(start)
+
+		closeDevices()
+
+		progressf("\n")
+		fmt.Println("ping:       ", delivered.Round(time.Millisecond))
+		fmt.Println("round trip: ", roundTrip.Round(time.Millisecond))
+	},
+}
+
+func init() {
+	deviceCommand.AddCommand(devicePingCommand)
+	devicePingCommand.Flags().StringVarP(&secretKey, "secret-key", "s", "", "Secret key of the device to send the ping from")
+	devicePingCommand.Flags().StringVarP(&recipientKey, "recipient-key", "r", "", "Secret key of the device to send the ping to")
+	devicePingCommand.Flags().DurationVarP(&pingWait, "wait", "w", 30*time.Second, "How long to wait for each message to be delivered")
+	addMessagingFlags(devicePingCommand)
+}
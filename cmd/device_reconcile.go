@@ -0,0 +1,205 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/joinself/self-go-sdk/pkg/siggraph"
+	"github.com/joinself/self-go-sdk/pkg/transport"
+	"github.com/olekukonko/tablewriter"
+	"github.com/spf13/cobra"
+)
+
+const (
+	reconcileDeactivate = "deactivate"
+	reconcileActivate   = "activate"
+	reconcileFlag       = "flag"
+)
+
+var (
+	activateDevices bool
+	dryRun          bool
+)
+
+// reconcileAction an action required to reconcile an advertised device with the signature graph
+type reconcileAction struct {
+	DID    string
+	KIDs   []string
+	Issue  string
+	Action string
+}
+
+var deviceReconcileCommand = &cobra.Command{
+	Use:   "reconcile",
+	Short: "reconciles advertised devices with the signature graph",
+	Long:  "deactivates advertised devices whose keys have all been revoked, and flags advertised devices that have no key. Devices with a valid key that are not advertised are flagged, or activated if '--activate' is specified",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) < 1 {
+			check(errors.New("you must specify an app identity [appID]"))
+		}
+
+		sk := mustSecretKey(secretKey, keyTypeSecret, "secret key")
+
+		client := rest(cmd.Context(), args[0], sk)
+
+		app, sg := getIdentity(client, args[0])
+
+		keys, err := keyHistory(app.History, sg)
+		check(err)
+
+		done := make(chan error)
+
+		go log("getting devices", done)
+
+		devices, err := fetchDevices(client, args[0])
+		done <- err
+
+		if err != nil {
+			exit(1)
+		}
+
+		actions := reconcile(keys, devices, time.Now())
+
+		progressf("\n")
+
+		if len(actions) < 1 {
+			fmt.Println("advertised devices match the signature graph")
+			return
+		}
+
+		var lines [][]string
+		var failed bool
+
+		for _, a := range actions {
+			result := "-"
+
+			switch {
+			case a.Action == reconcileFlag:
+			case dryRun:
+				result = paint(os.Stdout, colorYellow, "skipped")
+			default:
+				err = applyReconcile(client, args[0], a)
+				if err != nil {
+					failed = true
+					result = paint(os.Stdout, colorRed, err.Error())
+				} else {
+					result = paint(os.Stdout, colorGreen, "✓")
+				}
+			}
+
+			lines = append(lines, []string{a.DID, strings.Join(a.KIDs, ", "), a.Issue, a.Action, result})
+		}
+
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader([]string{"DID", "KIDS", "ISSUE", "ACTION", "RESULT"})
+		table.SetAlignment(tablewriter.ALIGN_LEFT)
+		table.SetAutoWrapText(false)
+		table.SetHeaderLine(false)
+		table.SetRowLine(false)
+		table.SetBorder(false)
+		table.SetCenterSeparator("")
+		table.SetColumnSeparator("")
+		table.AppendBulk(lines)
+		table.Render()
+
+		if failed {
+			exit(1)
+		}
+	},
+}
+
+func init() {
+	deviceCommand.AddCommand(deviceReconcileCommand)
+	deviceReconcileCommand.Flags().StringVarP(&secretKey, "secret-key", "s", "", "Device secret key")
+	deviceReconcileCommand.Flags().BoolVarP(&activateDevices, "activate", "A", false, "Activate devices with a valid key that are not advertised")
+	deviceReconcileCommand.Flags().BoolVarP(&dryRun, "dry-run", "n", false, "Show the actions required without applying them")
+}
+
+// reconcile compares the advertised devices with the device keys on the
+// signature graph, returning the actions required to reconcile them
+func reconcile(keys []*keyInfo, devices []string, now time.Time) []reconcileAction {
+	valid := make(map[string][]string)
+	revoked := make(map[string][]string)
+
+	for _, k := range keys {
+		if k.Type != siggraph.TypeDeviceKey {
+			continue
+		}
+
+		switch k.stateAt(now) {
+		case keyStateRevoked:
+			revoked[k.DID] = append(revoked[k.DID], k.KID)
+		default:
+			valid[k.DID] = append(valid[k.DID], k.KID)
+		}
+	}
+
+	advertised := append([]string{}, devices...)
+	sort.Strings(advertised)
+
+	var actions []reconcileAction
+
+	for _, did := range advertised {
+		switch {
+		case len(valid[did]) > 0:
+		case len(revoked[did]) > 0:
+			actions = append(actions, reconcileAction{
+				DID:    did,
+				KIDs:   revoked[did],
+				Issue:  "advertised device has no unrevoked keys",
+				Action: reconcileDeactivate,
+			})
+		default:
+			actions = append(actions, reconcileAction{
+				DID:    did,
+				Issue:  "advertised device has no key",
+				Action: reconcileFlag,
+			})
+		}
+	}
+
+	var unadvertised []string
+
+	for did := range valid {
+		if !contains(devices, did) {
+			unadvertised = append(unadvertised, did)
+		}
+	}
+
+	sort.Strings(unadvertised)
+
+	for _, did := range unadvertised {
+		a := reconcileAction{
+			DID:    did,
+			KIDs:   valid[did],
+			Issue:  "device has a valid key but is not advertised",
+			Action: reconcileFlag,
+		}
+
+		if activateDevices {
+			a.Action = reconcileActivate
+		}
+
+		actions = append(actions, a)
+	}
+
+	return actions
+}
+
+// applyReconcile activates or deactivates a device
+func applyReconcile(client *transport.Rest, selfID string, a reconcileAction) error {
+	switch a.Action {
+	case reconcileActivate:
+		return activateDevice(client, selfID, a.DID)
+	case reconcileDeactivate:
+		return deactivateDevice(client, selfID, a.DID)
+	}
+
+	return nil
+}
@@ -0,0 +1,128 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/joinself/self-go-sdk/pkg/siggraph"
+)
+
+// formatActions formats reconcile actions for comparison
+func formatActions(actions []reconcileAction) string {
+	var lines []string
+
+	for _, a := range actions {
+		lines = append(lines, fmt.Sprintf("%s %s %v", a.Action, a.DID, a.KIDs))
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func TestReconcile(t *testing.T) {
+	now := time.Now()
+
+	ti := newTestIdentity(t, "app", now.Add(-time.Hour), "1", "2", "3")
+
+	// device 2's key has been revoked, device 3's key is scheduled to be revoked
+	ti.add(t, ti.keys["1"], now.Add(-30*time.Minute),
+		siggraph.Action{
+			KID:           "2",
+			DID:           "2",
+			Type:          siggraph.TypeDeviceKey,
+			Action:        siggraph.ActionKeyRevoke,
+			EffectiveFrom: now.Add(-30 * time.Minute).Unix(),
+		},
+		siggraph.Action{
+			KID:           "3",
+			DID:           "3",
+			Type:          siggraph.TypeDeviceKey,
+			Action:        siggraph.ActionKeyRevoke,
+			EffectiveFrom: now.Add(time.Hour).Unix(),
+		},
+	)
+
+	keys, err := keyHistory(ti.history, ti.graph(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		devices  []string
+		activate bool
+		expected []string
+	}{
+		{
+			name:     "reconciled",
+			devices:  []string{"3", "1"},
+			expected: nil,
+		},
+		{
+			name:    "drifted",
+			devices: []string{"4", "2", "1"},
+			expected: []string{
+				"deactivate 2 [2]",
+				"flag 4 []",
+				"flag 3 [3]",
+			},
+		},
+		{
+			name:     "activate",
+			devices:  []string{"1"},
+			activate: true,
+			expected: []string{"activate 3 [3]"},
+		},
+		{
+			name:     "no devices",
+			devices:  nil,
+			expected: []string{"flag 1 [1]", "flag 3 [3]"},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			previous := activateDevices
+			defer func() { activateDevices = previous }()
+
+			activateDevices = tc.activate
+
+			actions := formatActions(reconcile(keys, tc.devices, now))
+
+			if actions != strings.Join(tc.expected, "\n") {
+				t.Fatalf("expected actions:\n%s\ngot:\n%s", strings.Join(tc.expected, "\n"), actions)
+			}
+		})
+	}
+}
+
+func TestApplyReconcile(t *testing.T) {
+	var requests []string
+
+	client := testRest(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path)
+	}))
+
+	actions := []reconcileAction{
+		{DID: "1", Action: reconcileDeactivate},
+		{DID: "2", Action: reconcileActivate},
+		{DID: "3", Action: reconcileFlag},
+	}
+
+	for _, a := range actions {
+		err := applyReconcile(client, "app", a)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected := "DELETE /v1/identities/app/devices/1,POST /v1/identities/app/devices"
+
+	if strings.Join(requests, ",") != expected {
+		t.Fatalf("expected requests %s, got %s", expected, strings.Join(requests, ","))
+	}
+}
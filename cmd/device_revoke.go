@@ -5,7 +5,6 @@ package cmd
 import (
 	"encoding/json"
 	"errors"
-	"os"
 
 	"github.com/joinself/self-go-sdk/pkg/ntp"
 	"github.com/joinself/self-go-sdk/pkg/siggraph"
@@ -20,11 +19,9 @@ var deviceRevokeCommand = &cobra.Command{
 			check(errors.New("you must specify an app identity and device [appID, deviceID]"))
 		}
 
-		if secretKey == "" {
-			check(errors.New("You must provide a secret key"))
-		}
+		sk := mustSecretKey(secretKey, keyTypeSecret, "secret key")
 
-		client := rest(args[0], secretKey)
+		client := rest(cmd.Context(), args[0], sk)
 
 		done := make(chan error)
 
@@ -35,7 +32,7 @@ var deviceRevokeCommand = &cobra.Command{
 		done <- err
 
 		if err != nil {
-			os.Exit(1)
+			exit(1)
 		}
 
 		// load the signature graph
@@ -44,7 +41,7 @@ var deviceRevokeCommand = &cobra.Command{
 		err = json.Unmarshal(resp, &app)
 		check(err)
 
-		sg, err := siggraph.New(app.History)
+		sg, err := loadGraph(args[0], app.History)
 		check(err)
 
 		var ef int64
@@ -68,10 +65,10 @@ var deviceRevokeCommand = &cobra.Command{
 			},
 		}
 
-		operation := newOperation(sg, actions, secretKey)
+		operation := newOperation(sg, actions, sk)
 
 		// check the operation is valid
-		err = sg.Execute(operation)
+		err = executeOperation(args[0], sg, operation)
 		check(err)
 
 		// creating a new device
@@ -81,7 +78,7 @@ var deviceRevokeCommand = &cobra.Command{
 		done <- err
 
 		if err != nil {
-			os.Exit(1)
+			exit(1)
 		}
 	},
 }
@@ -3,118 +3,278 @@
 package cmd
 
 import (
-	"crypto/rand"
-	"encoding/base64"
-	"encoding/json"
+	"context"
 	"errors"
 	"fmt"
 	"os"
 	"strconv"
+	"time"
 
 	"github.com/joinself/self-go-sdk/pkg/ntp"
 	"github.com/joinself/self-go-sdk/pkg/siggraph"
+	"github.com/joinself/self-go-sdk/pkg/transport"
+	"github.com/olekukonko/tablewriter"
 	"github.com/spf13/cobra"
-	"golang.org/x/crypto/ed25519"
 )
 
+var rotateAll bool
+
+// rotatedKey a device key created by rotating a devices key
+type rotatedKey struct {
+	DID       string
+	OldKID    string
+	KID       string
+	PublicKey string
+	SecretKey *key // the generated secret key, nil if a public key was provided
+}
+
 var deviceRotateCommand = &cobra.Command{
 	Use:   "rotate",
 	Short: "rotates a devices key",
+	Long:  "rotates a devices key, revoking its current key and adding a new one. If '--all' is specified, the keys of all devices are rotated, either for a single identity or for every identity in an inventory",
 	Run: func(cmd *cobra.Command, args []string) {
+		if rotateAll && devicePublicKey != "" {
+			check(errors.New("a device public key cannot be provided when rotating all devices"))
+		}
+
+		if inventoryFile != "" {
+			if !rotateAll {
+				check(errors.New("rotating devices for an inventory of identities requires '--all'"))
+			}
+
+			rotateInventoryDevices(cmd.Context(), mustLoadInventory())
+			return
+		}
+
+		if rotateAll {
+			if len(args) < 1 {
+				check(errors.New("you must specify an app identity [appID]"))
+			}
+
+			sk := mustSecretKey(secretKey, keyTypeSecret, "secret key")
+
+			done := make(chan error)
+
+			go log("rotating all device keys", done)
+
+			rotated, err := rotateAllDevices(rest(cmd.Context(), args[0], sk), args[0], sk)
+			done <- err
+
+			printRotatedKeys([]string{"DID", "OLD KID", "NEW KID", "SECRET KEY"}, rotatedLines(rotated))
+
+			if err != nil {
+				exit(1)
+			}
+
+			return
+		}
+
 		if len(args) < 2 {
 			check(errors.New("you must specify an app identity and device [appID, deviceID]"))
 		}
 
-		if secretKey == "" {
-			check(errors.New("You must provide a secret key"))
-		}
+		sk := mustSecretKey(secretKey, keyTypeSecret, "secret key")
 
 		var epk string
-		var esk string
 
-		if devicePublicKey == "" {
-			pk, sk, err := ed25519.GenerateKey(rand.Reader)
-			check(err)
-
-			epk = enc.EncodeToString(pk)
-			esk = base64.RawStdEncoding.EncodeToString(sk.Seed())
-		} else {
-			epk = devicePublicKey
+		if devicePublicKey != "" {
+			epk = mustPublicKey(devicePublicKey, "device public key")
 		}
 
-		client := rest(args[0], secretKey)
+		client := rest(cmd.Context(), args[0], sk)
+
+		_, sg := getIdentity(client, args[0])
 
 		done := make(chan error)
 
-		// get the identity history
-		go log("getting identity history", done)
+		// revoke old device and create a new device
+		go log("revoking old device key and creating new device key", done)
 
-		resp, err := client.Get("/v1/identities/" + args[0])
+		rotated, err := rotateDevices(client, args[0], sg, sk, []string{args[1]}, epk)
 		done <- err
 
+		if len(rotated) > 0 && rotated[0].SecretKey != nil {
+			dk := rotated[0].SecretKey
+
+			progressf("\n")
+			fmt.Println("device private key:  ", dk)
+			fmt.Println("device sdk secret:   ", dk.Legacy())
+			fmt.Println("device public key:   ", rotated[0].PublicKey)
+		}
+
 		if err != nil {
-			os.Exit(1)
+			exit(1)
+		}
+	},
+}
+
+func init() {
+	deviceCommand.AddCommand(deviceRotateCommand)
+	deviceRotateCommand.Flags().StringVarP(&secretKey, "secret-key", "s", "", "Device secret key")
+	deviceRotateCommand.Flags().StringVarP(&devicePublicKey, "device-public-key", "p", "", "New device public key")
+	deviceRotateCommand.Flags().BoolVarP(&rotateAll, "all", "A", false, "Rotate the keys of all devices")
+	deviceRotateCommand.Flags().StringVarP(&inventoryFile, "identities", "I", "", "Inventory file of identities to rotate all device keys for, requires '--all'")
+	deviceRotateCommand.Flags().IntVarP(&concurrency, "concurrency", "c", 4, "Number of identities to rotate device keys for concurrently")
+}
+
+// rotateInventoryDevices rotates the keys of all devices for every identity in an inventory
+func rotateInventoryDevices(ctx context.Context, inv *inventory) {
+	done := make(chan error)
+
+	go log(fmt.Sprintf("rotating all device keys for %d identities", len(inv.Identities)), done)
+
+	results := forEachIdentity(ctx, inv, func(i inventoryIdentity, sk *key) (interface{}, error) {
+		client, err := newRest(ctx, i.SelfID, sk)
+		if err != nil {
+			return nil, err
+		}
+
+		return rotateAllDevices(client, i.SelfID, sk)
+	})
+
+	done <- nil
+
+	var lines [][]string
+
+	for _, r := range results {
+		rotated, _ := r.Value.([]*rotatedKey)
+
+		for _, line := range rotatedLines(rotated) {
+			lines = append(lines, append([]string{r.SelfID}, line...))
+		}
+	}
+
+	printRotatedKeys([]string{"SELF ID", "DID", "OLD KID", "NEW KID", "SECRET KEY"}, lines)
+
+	if reportErrors(results) {
+		exit(1)
+	}
+}
+
+// rotateAllDevices rotates the keys of all devices that have a valid key
+func rotateAllDevices(client *transport.Rest, selfID string, sk *key) ([]*rotatedKey, error) {
+	identity, sg, err := fetchIdentity(client, selfID)
+	if err != nil {
+		return nil, err
+	}
+
+	keys, err := keyHistory(identity.History, sg)
+	if err != nil {
+		return nil, err
+	}
+
+	var dids []string
+
+	now := time.Now()
+
+	for _, k := range keys {
+		if k.Type == siggraph.TypeDeviceKey && k.stateAt(now) == keyStateValid && !contains(dids, k.DID) {
+			dids = append(dids, k.DID)
 		}
+	}
+
+	if len(dids) < 1 {
+		return nil, errors.New("identity does not have any devices with a valid key")
+	}
 
-		// load the signature graph
-		var app Identity
+	return rotateDevices(client, selfID, sg, sk, dids, "")
+}
 
-		err = json.Unmarshal(resp, &app)
-		check(err)
+// rotateDevices revokes the current key of each device and adds a new key, in a single
+// operation. If no public key is provided, a new key is generated for each device. The
+// rotated keys are returned even if submitting the operation fails, so that generated
+// keys are not lost
+func rotateDevices(client *transport.Rest, selfID string, sg *siggraph.SignatureGraph, sk *key, dids []string, publicKey string) ([]*rotatedKey, error) {
+	var rotated []*rotatedKey
+	var actions []siggraph.Action
 
-		sg, err := siggraph.New(app.History)
-		check(err)
+	next := len(sg.Keys()) + 1
 
-		// create a new operation
-		kid := strconv.Itoa(len(sg.Keys()) + 1)
+	// the signing key may be one of the keys being revoked, so the
+	// revocations must not take effect before the operation is signed
+	now := ntp.TimeFunc()
 
-		okid, err := sg.GetKeyID(args[1])
-		check(err)
+	for i, did := range dids {
+		okid, err := sg.GetKeyID(did)
+		if err != nil {
+			return nil, err
+		}
+
+		rk := &rotatedKey{
+			DID:       did,
+			OldKID:    okid,
+			KID:       strconv.Itoa(next + i),
+			PublicKey: publicKey,
+		}
 
-		actions := []siggraph.Action{
-			{
+		if rk.PublicKey == "" {
+			rk.SecretKey = generateKey(keyTypeSecret, rk.KID)
+			rk.PublicKey = enc.EncodeToString(rk.SecretKey.publicKey())
+		}
+
+		actions = append(actions,
+			siggraph.Action{
 				KID:           okid,
-				DID:           args[1],
+				DID:           did,
 				Type:          siggraph.TypeDeviceKey,
 				Action:        siggraph.ActionKeyRevoke,
-				EffectiveFrom: ntp.TimeFunc().Unix(),
+				EffectiveFrom: now.Unix(),
 			},
-			{
-				KID:           kid,
-				DID:           args[1],
+			siggraph.Action{
+				KID:           rk.KID,
+				DID:           did,
 				Type:          siggraph.TypeDeviceKey,
 				Action:        siggraph.ActionKeyAdd,
-				EffectiveFrom: ntp.TimeFunc().Unix(),
-				Key:           epk,
+				EffectiveFrom: now.Unix(),
+				Key:           rk.PublicKey,
 			},
-		}
+		)
 
-		operation := newOperation(sg, actions, secretKey)
+		rotated = append(rotated, rk)
+	}
 
-		// check the operation is valid
-		err = sg.Execute(operation)
-		check(err)
+	operation, err := signOperation(sg, actions, sk, now)
+	if err != nil {
+		return nil, err
+	}
 
-		// revoke old device and create a new device
-		go log("revoking old device key and creating new device key", done)
+	// check the operation is valid
+	err = executeOperation(selfID, sg, operation)
+	if err != nil {
+		return nil, err
+	}
 
-		resp, err = client.Post("/v1/identities/"+args[0]+"/history", "application/json", operation)
-		done <- err
+	_, err = client.Post("/v1/identities/"+selfID+"/history", "application/json", operation)
 
-		if esk != "" {
-			fmt.Println("")
-			fmt.Println("device private key:  ", kid+":"+esk)
-			fmt.Println("device public key:   ", epk)
-		}
+	return rotated, err
+}
 
-		if err != nil {
-			os.Exit(1)
-		}
-	},
+func rotatedLines(rotated []*rotatedKey) [][]string {
+	var lines [][]string
+
+	for _, rk := range rotated {
+		lines = append(lines, []string{rk.DID, rk.OldKID, rk.KID, rk.SecretKey.String()})
+	}
+
+	return lines
 }
 
-func init() {
-	deviceCommand.AddCommand(deviceRotateCommand)
-	deviceRotateCommand.Flags().StringVarP(&secretKey, "secret-key", "s", "", "Device secret key")
-	deviceRotateCommand.Flags().StringVarP(&devicePublicKey, "device-public-key", "p", "", "New device public key")
+func printRotatedKeys(header []string, lines [][]string) {
+	if len(lines) < 1 {
+		return
+	}
+
+	progressf("\n")
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader(header)
+	table.SetAlignment(tablewriter.ALIGN_LEFT)
+	table.SetAutoWrapText(false)
+	table.SetHeaderLine(false)
+	table.SetRowLine(false)
+	table.SetBorder(false)
+	table.SetCenterSeparator("")
+	table.SetColumnSeparator("")
+	table.AppendBulk(lines)
+	table.Render()
 }
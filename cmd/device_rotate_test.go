@@ -0,0 +1,58 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package cmd
+
+import (
+	"testing"
+	"time"
+
+	"github.com/joinself/self-go-sdk/pkg/siggraph"
+)
+
+func TestRotateAllDevices(t *testing.T) {
+	ti := newTestIdentity(t, "app", time.Now().Add(-time.Hour), "1", "2")
+
+	client := testRest(t, ti)
+
+	// the signing device is rotated along with the other devices
+	rotated, err := rotateAllDevices(client, "app", ti.keys["1"])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rotated) != 2 {
+		t.Fatalf("expected 2 rotated keys, got %d", len(rotated))
+	}
+
+	if len(ti.history) != 2 {
+		t.Fatalf("expected the rotation to be posted to the history, history has %d operations", len(ti.history))
+	}
+
+	sg := ti.graph(t)
+
+	op, err := siggraph.ParseOperation(ti.history[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, a := range op.Actions {
+		if a.EffectiveFrom != op.Timestamp {
+			t.Fatalf("expected action on key %s to take effect at %d, got %d", a.KID, op.Timestamp, a.EffectiveFrom)
+		}
+	}
+
+	for _, rk := range rotated {
+		kid, err := sg.GetKeyID(rk.DID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if kid != rk.KID {
+			t.Fatalf("expected device %s to have key %s, got %s", rk.DID, rk.KID, kid)
+		}
+
+		if rk.SecretKey == nil || rk.SecretKey.kid != rk.KID {
+			t.Fatalf("expected a secret key to be generated for device %s", rk.DID)
+		}
+	}
+}
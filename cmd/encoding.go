@@ -0,0 +1,274 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package cmd
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/ed25519"
+)
+
+const (
+	// keyTypeSecret a device secret key
+	keyTypeSecret = "sk"
+	// keyTypeRecovery a recovery secret key
+	keyTypeRecovery = "rk"
+	// keyTypePublic a device or recovery public key
+	keyTypePublic = "pk"
+
+	checksumLength = 4
+)
+
+var (
+	errKeyEmpty          = errors.New("key is empty")
+	errKeyMissingKID     = errors.New("key does not contain a key identifier, expected '<kid>:<key>'")
+	errKeyEncoding       = errors.New("key is not correctly base64 encoded, check it has been copied in full")
+	errKeyLength         = errors.New("key has an invalid length, check it has been copied in full")
+	errKeyChecksum       = errors.New("key checksum does not match, check it has been copied correctly")
+	errKeyNotSecret      = errors.New("key is not a secret key")
+	errKeyPublicRequired = errors.New("key is not a public key")
+)
+
+// key a decoded device, recovery or public key
+type key struct {
+	typ    string // type of the key [sk, rk, pk], empty for legacy secret keys
+	kid    string // key identifier on the signature graph
+	data   []byte // the ed25519 seed for secret keys, or the public key
+	legacy bool   // whether the key was provided in the legacy format
+}
+
+// newKey creates a key from its type, identifier and raw key data
+func newKey(typ, kid string, data []byte) *key {
+	return &key{typ: typ, kid: kid, data: data}
+}
+
+// generateKey generates a new secret key of the given type
+func generateKey(typ, kid string) *key {
+	_, sk, err := ed25519.GenerateKey(rand.Reader)
+	check(err)
+
+	return newKey(typ, kid, sk.Seed())
+}
+
+// decodeKey decodes a key from either the prefixed and checksummed
+// format '<type>_<kid>:<data>', or the legacy '<kid>:<seed>' format.
+// The key identifier of a prefixed key may be empty if it has not
+// yet been assigned one on the signature graph
+func decodeKey(s string) (*key, error) {
+	s = strings.TrimSpace(s)
+
+	if s == "" {
+		return nil, errKeyEmpty
+	}
+
+	k, err := parseKey(s, true)
+	if err == nil {
+		return k, nil
+	}
+
+	// a bare legacy public key may start with the same
+	// characters as a type prefix, i.e. 'pk_'
+	lk, lerr := parseKey(s, false)
+	if lerr == nil {
+		return lk, nil
+	}
+
+	return nil, err
+}
+
+// parseKey parses a key, detecting its type prefix unless prefixed is false,
+// in which case the key is parsed in the legacy format
+func parseKey(s string, prefixed bool) (*key, error) {
+	k := key{}
+
+	for _, typ := range []string{keyTypeSecret, keyTypeRecovery, keyTypePublic} {
+		if prefixed && strings.HasPrefix(s, typ+"_") {
+			k.typ = typ
+			s = strings.TrimPrefix(s, typ+"_")
+			break
+		}
+	}
+
+	k.legacy = k.typ == ""
+
+	kp := strings.Split(s, ":")
+
+	switch {
+	case len(kp) == 2:
+		k.kid = kp[0]
+		s = kp[1]
+	case len(kp) == 1 && k.typ == keyTypePublic:
+		// public keys may be provided without a key identifier
+	case len(kp) == 1 && k.legacy:
+		// a bare legacy key can only be a public key
+		k.typ = keyTypePublic
+	default:
+		return nil, errKeyMissingKID
+	}
+
+	if k.kid == "" && k.legacy && k.typ != keyTypePublic {
+		return nil, errKeyMissingKID
+	}
+
+	data, err := decodeKeyData(s)
+	if err != nil {
+		return nil, err
+	}
+
+	switch len(data) {
+	case ed25519.SeedSize:
+		// keys without a checksum are accepted for compatibility
+		k.legacy = true
+	case ed25519.SeedSize + checksumLength:
+		if k.typ == "" {
+			return nil, errKeyLength
+		}
+
+		sum := data[ed25519.SeedSize:]
+		data = data[:ed25519.SeedSize]
+
+		if !bytes.Equal(sum, checksum(k.typ, k.kid, data)) {
+			return nil, errKeyChecksum
+		}
+	default:
+		return nil, errKeyLength
+	}
+
+	k.data = data
+
+	return &k, nil
+}
+
+// decodeKeyData decodes key data that may be url or standard base64 encoded
+func decodeKeyData(s string) ([]byte, error) {
+	data, err := enc.DecodeString(s)
+	if err == nil {
+		return data, nil
+	}
+
+	data, err = base64.RawStdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errKeyEncoding
+	}
+
+	return data, nil
+}
+
+// checksum computes the checksum of a keys type, identifier and data
+func checksum(typ, kid string, data []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte(typ + "_" + kid + ":"))
+	h.Write(data)
+
+	return h.Sum(nil)[:checksumLength]
+}
+
+// String encodes the key in the prefixed and checksummed format
+func (k *key) String() string {
+	typ := k.typ
+	if typ == "" {
+		typ = keyTypeSecret
+	}
+
+	data := append(append([]byte{}, k.data...), checksum(typ, k.kid, k.data)...)
+
+	if typ == keyTypePublic && k.kid == "" {
+		return typ + "_" + enc.EncodeToString(data)
+	}
+
+	return typ + "_" + k.kid + ":" + enc.EncodeToString(data)
+}
+
+// Legacy encodes the key in the legacy format used by the sdk's
+// 'SELF_APP_DEVICE_SECRET', or the bare encoding used by the api for public keys
+func (k *key) Legacy() string {
+	if k.typ == keyTypePublic {
+		return enc.EncodeToString(k.data)
+	}
+
+	return k.kid + ":" + base64.RawStdEncoding.EncodeToString(k.data)
+}
+
+// isSecret returns true if the key holds private key material
+func (k *key) isSecret() bool {
+	return k.typ != keyTypePublic
+}
+
+// privateKey returns the ed25519 private key for a secret key
+func (k *key) privateKey() ed25519.PrivateKey {
+	return ed25519.NewKeyFromSeed(k.data)
+}
+
+// publicKey returns the ed25519 public key, deriving it if the key is a secret key
+func (k *key) publicKey() ed25519.PublicKey {
+	if k.isSecret() {
+		return k.privateKey().Public().(ed25519.PublicKey)
+	}
+
+	return ed25519.PublicKey(k.data)
+}
+
+// public returns the public half of the key
+func (k *key) public() *key {
+	return newKey(keyTypePublic, k.kid, k.publicKey())
+}
+
+// mustSecretKey decodes and validates a secret key of the expected type,
+// exiting with an error describing the problem if it is not valid
+func mustSecretKey(s, typ, name string) *key {
+	k, err := parseSecretKey(s, typ, name)
+	check(err)
+
+	return k
+}
+
+// parseSecretKey decodes and validates a secret key of the given type
+func parseSecretKey(s, typ, name string) (*key, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, fmt.Errorf("you must provide a %s", name)
+	}
+
+	k, err := decodeKey(s)
+	if err != nil {
+		return nil, fmt.Errorf("the %s provided is not valid: %w", name, err)
+	}
+
+	if !k.isSecret() {
+		return nil, fmt.Errorf("the %s provided is not valid: %w", name, errKeyNotSecret)
+	}
+
+	if k.typ != "" && k.typ != typ {
+		return nil, fmt.Errorf("the %s provided is not valid, it should start with '%s_'", name, typ)
+	}
+
+	if k.typ == "" {
+		k.typ = typ
+	}
+
+	if k.kid == "" {
+		return nil, fmt.Errorf("the %s provided is not valid: %w", name, errKeyMissingKID)
+	}
+
+	return k, nil
+}
+
+// mustPublicKey decodes and validates a public key, returning it in
+// the encoding expected by the api
+func mustPublicKey(s, name string) string {
+	k, err := decodeKey(s)
+	if err != nil {
+		check(fmt.Errorf("the %s provided is not valid: %w", name, err))
+	}
+
+	if k.isSecret() {
+		check(fmt.Errorf("the %s provided is not valid: %w", name, errKeyPublicRequired))
+	}
+
+	return enc.EncodeToString(k.data)
+}
@@ -0,0 +1,208 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ed25519"
+)
+
+func TestEncodeDecodeKey(t *testing.T) {
+	sk := generateKey(keyTypeSecret, "1")
+
+	tests := []struct {
+		name string
+		key  *key
+	}{
+		{"secret", sk},
+		{"recovery", generateKey(keyTypeRecovery, "2")},
+		{"public", sk.public()},
+		{"public without kid", newKey(keyTypePublic, "", sk.publicKey())},
+		{"secret without kid", newKey(keyTypeSecret, "", sk.data)},
+		{"kid with separators", newKey(keyTypeSecret, "device_1-a", sk.data)},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			encoded := tc.key.String()
+
+			if !strings.HasPrefix(encoded, tc.key.typ+"_") {
+				t.Fatalf("expected key to be prefixed with its type, got %s", encoded)
+			}
+
+			k, err := decodeKey(encoded)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if k.typ != tc.key.typ || k.kid != tc.key.kid || k.legacy {
+				t.Fatalf("expected %s key %s, got %s key %s (legacy %t)", tc.key.typ, tc.key.kid, k.typ, k.kid, k.legacy)
+			}
+
+			if !bytes.Equal(k.data, tc.key.data) {
+				t.Fatal("decoded key does not match the encoded key")
+			}
+		})
+	}
+}
+
+func TestDecodeLegacyKey(t *testing.T) {
+	sk := generateKey(keyTypeSecret, "1")
+	unchecked := enc.EncodeToString(sk.data)
+
+	tests := []struct {
+		name    string
+		encoded string
+		typ     string
+		kid     string
+	}{
+		{"sdk secret", sk.Legacy(), "", "1"},
+		{"sdk secret with whitespace", "  " + sk.Legacy() + "\n", "", "1"},
+		{"api public key", sk.public().Legacy(), keyTypePublic, ""},
+		{"prefixed without checksum", "sk_1:" + unchecked, keyTypeSecret, "1"},
+		{"standard base64", "sk_1:" + base64.RawStdEncoding.EncodeToString(sk.data), keyTypeSecret, "1"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			k, err := decodeKey(tc.encoded)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !k.legacy {
+				t.Fatal("expected the key to be decoded as a legacy key")
+			}
+
+			if k.typ != tc.typ || k.kid != tc.kid {
+				t.Fatalf("expected %s key %s, got %s key %s", tc.typ, tc.kid, k.typ, k.kid)
+			}
+
+			expected := sk.data
+			if tc.typ == keyTypePublic {
+				expected = sk.publicKey()
+			}
+
+			if !bytes.Equal(k.data, expected) {
+				t.Fatal("decoded key does not match the encoded key")
+			}
+		})
+	}
+}
+
+func TestDecodeLegacyPublicKeyWithTypePrefix(t *testing.T) {
+	for _, typ := range []string{keyTypeSecret, keyTypeRecovery, keyTypePublic} {
+		t.Run(typ, func(t *testing.T) {
+			// a public key whose bare encoding starts with a type prefix
+			encoded := typ + "_" + strings.Repeat("A", 39) + "E"
+
+			data, err := enc.DecodeString(encoded)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(data) != ed25519.PublicKeySize {
+				t.Fatalf("expected a %d byte public key, got %d bytes", ed25519.PublicKeySize, len(data))
+			}
+
+			k, err := decodeKey(encoded)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if k.typ != keyTypePublic || k.kid != "" || !k.legacy || !bytes.Equal(k.data, data) {
+				t.Fatalf("expected a legacy public key, got %s key '%s' (legacy %t)", k.typ, k.kid, k.legacy)
+			}
+
+			if k.Legacy() != encoded {
+				t.Fatalf("expected the key to be encoded as %s, got %s", encoded, k.Legacy())
+			}
+		})
+	}
+}
+
+func TestDecodeKeyErrors(t *testing.T) {
+	sk := generateKey(keyTypeSecret, "1")
+	encoded := sk.String()
+
+	data, err := enc.DecodeString(strings.TrimPrefix(encoded, "sk_1:"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// flip a bit in the key, leaving the checksum as it was
+	corrupted := append([]byte{}, data...)
+	corrupted[0] ^= 1
+
+	tests := []struct {
+		name    string
+		encoded string
+		err     error
+	}{
+		{"empty", " ", errKeyEmpty},
+		{"legacy without kid", ":" + base64.RawStdEncoding.EncodeToString(sk.data), errKeyMissingKID},
+		{"secret without kid", "sk_" + enc.EncodeToString(data), errKeyMissingKID},
+		{"invalid encoding", "sk_1:not*base64", errKeyEncoding},
+		{"truncated", encoded[:len(encoded)-4], errKeyLength},
+		{"checksummed legacy key", "1:" + enc.EncodeToString(data), errKeyLength},
+		{"corrupted", "sk_1:" + enc.EncodeToString(corrupted), errKeyChecksum},
+		{"wrong kid", "sk_2:" + enc.EncodeToString(data), errKeyChecksum},
+		{"wrong type", "rk_1:" + enc.EncodeToString(data), errKeyChecksum},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := decodeKey(tc.encoded)
+			if !errors.Is(err, tc.err) {
+				t.Fatalf("expected error '%v', got '%v'", tc.err, err)
+			}
+		})
+	}
+}
+
+func TestParseSecretKey(t *testing.T) {
+	sk := generateKey(keyTypeSecret, "1")
+	rk := generateKey(keyTypeRecovery, "2")
+
+	tests := []struct {
+		name    string
+		encoded string
+		typ     string
+		err     bool
+	}{
+		{"secret", sk.String(), keyTypeSecret, false},
+		{"recovery", rk.String(), keyTypeRecovery, false},
+		{"legacy secret", sk.Legacy(), keyTypeSecret, false},
+		{"legacy recovery", rk.Legacy(), keyTypeRecovery, false},
+		{"empty", "", keyTypeSecret, true},
+		{"public", sk.public().String(), keyTypeSecret, true},
+		{"recovery as secret", rk.String(), keyTypeSecret, true},
+		{"without kid", newKey(keyTypeSecret, "", sk.data).String(), keyTypeSecret, true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			k, err := parseSecretKey(tc.encoded, tc.typ, "secret key")
+			if tc.err {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// legacy keys take the type they are expected to be
+			if k.typ != tc.typ || k.kid == "" {
+				t.Fatalf("expected a %s key with a kid, got %s key '%s'", tc.typ, k.typ, k.kid)
+			}
+		})
+	}
+}
@@ -0,0 +1,137 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/olekukonko/tablewriter"
+	"github.com/spf13/cobra"
+)
+
+const (
+	envProduction = "production"
+	envDev        = "dev"
+)
+
+// environment an environment the cli can target
+type environment struct {
+	Name         string `mapstructure:"-"`
+	APIURL       string `mapstructure:"api_url"`
+	MessagingURL string `mapstructure:"messaging_url"`
+	CABundle     string `mapstructure:"ca_bundle"`
+}
+
+var environmentCommand = &cobra.Command{
+	Use:   "environments",
+	Short: "lists the environments that can be targeted",
+	Long:  "lists the environments in the config's environment registry. Any other environment name targets the self hosted environment 'https://api.<env>.joinself.com'",
+	Run: func(cmd *cobra.Command, args []string) {
+		registry, err := environments()
+		check(err)
+
+		current := v.GetString("self_env")
+
+		var names []string
+
+		for name := range registry {
+			names = append(names, name)
+		}
+
+		sort.Strings(names)
+
+		var lines [][]string
+
+		for _, name := range names {
+			env := registry[name]
+
+			marker := ""
+			if name == current {
+				marker = "*"
+			}
+
+			lines = append(lines, []string{marker, name, env.APIURL, env.MessagingURL, env.CABundle})
+		}
+
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader([]string{"", "NAME", "API URL", "MESSAGING URL", "CA BUNDLE"})
+		table.SetAlignment(tablewriter.ALIGN_LEFT)
+		table.SetAutoWrapText(false)
+		table.SetHeaderLine(false)
+		table.SetRowLine(false)
+		table.SetBorder(false)
+		table.SetCenterSeparator("")
+		table.SetColumnSeparator("")
+		table.AppendBulk(lines)
+		table.Render()
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(environmentCommand)
+}
+
+// environments returns the builtin environments, merged with the
+// environments from the config's environment registry
+func environments() (map[string]*environment, error) {
+	registry := map[string]*environment{
+		envProduction: {
+			APIURL:       "https://api.joinself.com",
+			MessagingURL: "wss://messaging.joinself.com/v2/messaging",
+		},
+		envDev: {
+			APIURL: "http://api:8080",
+		},
+	}
+
+	var configured map[string]*environment
+
+	err := v.UnmarshalKey("environments", &configured)
+	if err != nil {
+		return nil, fmt.Errorf("environment registry is not valid: %w", err)
+	}
+
+	for name, env := range configured {
+		if env == nil || env.APIURL == "" {
+			return nil, fmt.Errorf("environment '%s' must specify an api_url", name)
+		}
+
+		registry[name] = env
+	}
+
+	for name, env := range registry {
+		env.Name = name
+		env.APIURL = strings.TrimSuffix(env.APIURL, "/")
+	}
+
+	return registry, nil
+}
+
+// currentEnvironment returns the environment specified by the '--env' flag or
+// 'SELF_ENV'. Environments that are not in the registry are assumed to be self
+// hosted environments, following the 'api.<env>.joinself.com' convention
+func currentEnvironment() (*environment, error) {
+	registry, err := environments()
+	if err != nil {
+		return nil, err
+	}
+
+	name := v.GetString("self_env")
+	if name == "" {
+		name = envProduction
+	}
+
+	env, ok := registry[name]
+	if ok {
+		return env, nil
+	}
+
+	return &environment{
+		Name:         name,
+		APIURL:       "https://api." + name + ".joinself.com",
+		MessagingURL: "wss://messaging." + name + ".joinself.com/v2/messaging",
+	}, nil
+}
@@ -0,0 +1,188 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package cmd
+
+import (
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+// setConfig loads a yaml config for the duration of a test
+func setConfig(t *testing.T, config string) {
+	t.Helper()
+
+	previous := v
+	t.Cleanup(func() { v = previous })
+
+	v = viper.New()
+	v.SetConfigType("yaml")
+
+	err := v.ReadConfig(strings.NewReader(config))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+const testRegistry = `
+environments:
+  production:
+    api_url: https://api.example.com/
+  local:
+    api_url: http://localhost:8080/
+    messaging_url: ws://localhost:8086/v2/messaging
+    ca_bundle: /etc/ssl/local.pem
+`
+
+func TestEnvironments(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   string
+		expected []string
+		err      bool
+	}{
+		{
+			name:   "builtin",
+			config: "",
+			expected: []string{
+				"dev http://api:8080 ",
+				"production https://api.joinself.com wss://messaging.joinself.com/v2/messaging",
+			},
+		},
+		{
+			name:   "registry",
+			config: testRegistry,
+			expected: []string{
+				"dev http://api:8080 ",
+				"local http://localhost:8080 ws://localhost:8086/v2/messaging",
+				"production https://api.example.com ",
+			},
+		},
+		{
+			name:   "missing api url",
+			config: "environments:\n  local:\n    messaging_url: ws://localhost:8086/v2/messaging\n",
+			err:    true,
+		},
+		{
+			name:   "not a registry",
+			config: "environments: local\n",
+			err:    true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			setConfig(t, tc.config)
+
+			registry, err := environments()
+			if tc.err {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var envs []string
+
+			for name, env := range registry {
+				if env.Name != name {
+					t.Fatalf("expected environment %s to be named %s, got %s", name, name, env.Name)
+				}
+
+				envs = append(envs, strings.Join([]string{name, env.APIURL, env.MessagingURL}, " "))
+			}
+
+			sort.Strings(envs)
+
+			if strings.Join(envs, "\n") != strings.Join(tc.expected, "\n") {
+				t.Fatalf("expected environments:\n%s\ngot:\n%s", strings.Join(tc.expected, "\n"), strings.Join(envs, "\n"))
+			}
+		})
+	}
+}
+
+func TestCurrentEnvironment(t *testing.T) {
+	tests := []struct {
+		name         string
+		env          string
+		apiURL       string
+		messagingURL string
+		expectedAPI  string
+		expectedMsg  string
+		messagingErr bool
+	}{
+		{
+			name:        "default",
+			expectedAPI: "https://api.example.com",
+			// the registry's production environment replaces the builtin one
+			messagingErr: true,
+		},
+		{
+			name:        "registry",
+			env:         "local",
+			expectedAPI: "http://localhost:8080",
+			expectedMsg: "ws://localhost:8086/v2/messaging",
+		},
+		{
+			name:        "self hosted",
+			env:         "staging",
+			expectedAPI: "https://api.staging.joinself.com",
+			expectedMsg: "wss://messaging.staging.joinself.com/v2/messaging",
+		},
+		{
+			name:        "api url overrides environment",
+			env:         "local",
+			apiURL:      "http://127.0.0.1:9000/",
+			expectedAPI: "http://127.0.0.1:9000",
+			expectedMsg: "ws://localhost:8086/v2/messaging",
+		},
+		{
+			name:         "messaging url overrides environment",
+			env:          "staging",
+			messagingURL: "http://127.0.0.1:8086",
+			expectedAPI:  "https://api.staging.joinself.com",
+			expectedMsg:  "http://127.0.0.1:8086",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			setConfig(t, testRegistry)
+
+			v.Set("self_env", tc.env)
+			v.Set("self_api_url", tc.apiURL)
+			v.Set("self_messaging_url", tc.messagingURL)
+
+			api, err := resolveAPIURL()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if api != tc.expectedAPI {
+				t.Fatalf("expected api url %s, got %s", tc.expectedAPI, api)
+			}
+
+			msg, err := messagingURL()
+			if tc.messagingErr {
+				if err == nil {
+					t.Fatalf("expected an error for an environment without a messaging url, got %s", msg)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if msg != tc.expectedMsg {
+				t.Fatalf("expected messaging url %s, got %s", tc.expectedMsg, msg)
+			}
+		})
+	}
+}
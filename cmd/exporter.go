@@ -0,0 +1,242 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/joinself/self-go-sdk/pkg/siggraph"
+	"github.com/joinself/self-go-sdk/pkg/transport"
+	"github.com/spf13/cobra"
+)
+
+// identityMetrics the key health metrics of an identity
+type identityMetrics struct {
+	ActiveDeviceKeys   int
+	RevokedDeviceKeys  int
+	OldestKeyAge       int64
+	RecoveryKey        bool
+	PendingRevocations int
+	AdvertisedDevices  int
+	LastSuccess        int64
+	FetchErrors        int
+	Up                 bool
+}
+
+// metricsCollector periodically collects metrics for a set of identities
+type metricsCollector struct {
+	client  *transport.Rest
+	mu      sync.Mutex
+	metrics map[string]*identityMetrics
+}
+
+var exporterCommand = &cobra.Command{
+	Use:   "exporter",
+	Short: "exposes identity key health as prometheus metrics",
+	Long:  "periodically loads the signature graph and devices of each identity in an inventory, exposing the health of their keys as prometheus metrics on '/metrics'",
+	Run: func(cmd *cobra.Command, args []string) {
+		if inventoryFile == "" {
+			check(errors.New("you must specify an inventory of identities [--identities]"))
+		}
+
+		inv := mustLoadInventory()
+
+		sk := mustSecretKey(secretKey, keyTypeSecret, "secret key")
+
+		if appID == "" {
+			appID = inv.Identities[0].SelfID
+		}
+
+		c := &metricsCollector{
+			client:  rest(cmd.Context(), appID, sk),
+			metrics: make(map[string]*identityMetrics),
+		}
+
+		ctx := cmd.Context()
+
+		go func() {
+			for {
+				for _, i := range inv.Identities {
+					c.collect(i.SelfID)
+				}
+
+				if !sleep(ctx, pollInterval) {
+					return
+				}
+			}
+		}()
+
+		mux := http.NewServeMux()
+		mux.HandleFunc("/metrics", c.serve)
+
+		progressf("serving metrics on %s\n", metricsAddr)
+
+		check(serve(ctx, metricsAddr, mux))
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(exporterCommand)
+	exporterCommand.Flags().StringVarP(&secretKey, "secret-key", "s", "", "Device secret key")
+	exporterCommand.Flags().StringVarP(&appID, "app-id", "a", "", "App identity to authenticate as, defaults to the first identity in the inventory")
+	exporterCommand.Flags().StringVarP(&inventoryFile, "identities", "I", "", "Inventory file of identities to export metrics for")
+	exporterCommand.Flags().StringVarP(&metricsAddr, "listen", "l", ":9798", "Address to serve metrics on")
+	exporterCommand.Flags().DurationVarP(&pollInterval, "interval", "i", time.Minute, "Interval to collect metrics at")
+}
+
+// collect fetches an identities history and devices and updates its metrics
+func (c *metricsCollector) collect(selfID string) {
+	m, err := identityHealth(c.client, selfID)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	previous, ok := c.metrics[selfID]
+	if !ok {
+		previous = &identityMetrics{}
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to collect metrics for identity '%s': %s\n", selfID, err.Error())
+
+		previous.FetchErrors++
+		previous.Up = false
+		c.metrics[selfID] = previous
+
+		return
+	}
+
+	m.FetchErrors = previous.FetchErrors
+	c.metrics[selfID] = m
+}
+
+// serve writes all metrics in the prometheus text exposition format
+func (c *metricsCollector) serve(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var ids []string
+
+	for id := range c.metrics {
+		ids = append(ids, id)
+	}
+
+	sort.Strings(ids)
+
+	var b strings.Builder
+
+	metric := func(name, typ, help string, value func(m *identityMetrics) string) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+
+		for _, id := range ids {
+			fmt.Fprintf(&b, "%s{self_id=%q} %s\n", name, id, value(c.metrics[id]))
+		}
+	}
+
+	metric("self_identity_up", "gauge", "Whether the last fetch of the identity succeeded.", func(m *identityMetrics) string {
+		return boolMetric(m.Up)
+	})
+	metric("self_identity_fetch_errors_total", "counter", "Number of failed fetches of the identity.", func(m *identityMetrics) string {
+		return fmt.Sprint(m.FetchErrors)
+	})
+	metric("self_identity_last_success_timestamp_seconds", "gauge", "Unix time of the last successful fetch of the identity.", func(m *identityMetrics) string {
+		return fmt.Sprint(m.LastSuccess)
+	})
+	metric("self_identity_active_device_keys", "gauge", "Number of valid device keys.", func(m *identityMetrics) string {
+		return fmt.Sprint(m.ActiveDeviceKeys)
+	})
+	metric("self_identity_revoked_device_keys", "gauge", "Number of revoked device keys.", func(m *identityMetrics) string {
+		return fmt.Sprint(m.RevokedDeviceKeys)
+	})
+	metric("self_identity_oldest_key_age_seconds", "gauge", "Age of the oldest unrevoked device key.", func(m *identityMetrics) string {
+		return fmt.Sprint(m.OldestKeyAge)
+	})
+	metric("self_identity_recovery_key_present", "gauge", "Whether the identity has an unrevoked recovery key.", func(m *identityMetrics) string {
+		return boolMetric(m.RecoveryKey)
+	})
+	metric("self_identity_pending_revocations", "gauge", "Number of keys with a revocation that takes effect in the future.", func(m *identityMetrics) string {
+		return fmt.Sprint(m.PendingRevocations)
+	})
+	metric("self_identity_advertised_devices", "gauge", "Number of devices advertised as available for receiving messages.", func(m *identityMetrics) string {
+		return fmt.Sprint(m.AdvertisedDevices)
+	})
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write([]byte(b.String()))
+}
+
+// identityHealth fetches an identities history and devices, and computes the health of its keys
+func identityHealth(client *transport.Rest, selfID string) (*identityMetrics, error) {
+	identity, sg, err := fetchIdentity(client, selfID)
+	if err != nil {
+		return nil, err
+	}
+
+	keys, err := keyHistory(identity.History, sg)
+	if err != nil {
+		return nil, err
+	}
+
+	devices, err := fetchDevices(client, selfID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+
+	m := identityMetrics{
+		AdvertisedDevices: len(devices),
+		LastSuccess:       now.Unix(),
+		Up:                true,
+	}
+
+	var oldest int64
+
+	for _, k := range keys {
+		if k.RevokedAt > now.Unix() {
+			m.PendingRevocations++
+		}
+
+		// keys with a revocation that takes effect in the future are still unrevoked
+		revoked := k.stateAt(now) == keyStateRevoked
+
+		if k.Type == siggraph.TypeRecoveryKey {
+			if !revoked {
+				m.RecoveryKey = true
+			}
+			continue
+		}
+
+		switch k.stateAt(now) {
+		case keyStateValid:
+			m.ActiveDeviceKeys++
+		case keyStateRevoked:
+			m.RevokedDeviceKeys++
+		}
+
+		if !revoked && (oldest == 0 || k.CreatedAt < oldest) {
+			oldest = k.CreatedAt
+		}
+	}
+
+	if oldest != 0 {
+		m.OldestKeyAge = now.Unix() - oldest
+	}
+
+	return &m, nil
+}
+
+func boolMetric(v bool) string {
+	if v {
+		return "1"
+	}
+
+	return "0"
+}
@@ -0,0 +1,80 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package cmd
+
+import (
+	"testing"
+	"time"
+
+	"github.com/joinself/self-go-sdk/pkg/siggraph"
+)
+
+func TestIdentityHealth(t *testing.T) {
+	now := time.Now()
+
+	ti := newTestIdentity(t, "app", now.Add(-2*time.Hour), "1", "2")
+
+	nk := generateKey(keyTypeSecret, "4")
+	rk := generateKey(keyTypeRecovery, "5")
+
+	// a new device is added, and the original keys are scheduled to be revoked
+	ti.add(t, ti.keys["1"], now.Add(-time.Hour),
+		siggraph.Action{
+			KID:           nk.kid,
+			DID:           "3",
+			Type:          siggraph.TypeDeviceKey,
+			Action:        siggraph.ActionKeyAdd,
+			EffectiveFrom: now.Add(-time.Hour).Unix(),
+			Key:           enc.EncodeToString(nk.publicKey()),
+		},
+		siggraph.Action{
+			KID:           "1",
+			DID:           "1",
+			Type:          siggraph.TypeDeviceKey,
+			Action:        siggraph.ActionKeyRevoke,
+			EffectiveFrom: now.Add(time.Hour).Unix(),
+		},
+		siggraph.Action{
+			KID:           "2",
+			DID:           "2",
+			Type:          siggraph.TypeDeviceKey,
+			Action:        siggraph.ActionKeyRevoke,
+			EffectiveFrom: now.Add(time.Hour).Unix(),
+		},
+		siggraph.Action{
+			KID:           "3",
+			Type:          siggraph.TypeRecoveryKey,
+			Action:        siggraph.ActionKeyRevoke,
+			EffectiveFrom: now.Add(time.Hour).Unix(),
+		},
+		siggraph.Action{
+			KID:           rk.kid,
+			Type:          siggraph.TypeRecoveryKey,
+			Action:        siggraph.ActionKeyAdd,
+			EffectiveFrom: now.Add(time.Hour).Unix(),
+			Key:           enc.EncodeToString(rk.publicKey()),
+		},
+	)
+
+	m, err := identityHealth(testRest(t, ti), "app")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !m.Up || m.AdvertisedDevices != 2 {
+		t.Fatalf("unexpected metrics %+v", m)
+	}
+
+	if m.ActiveDeviceKeys != 3 || m.RevokedDeviceKeys != 0 || m.PendingRevocations != 3 {
+		t.Fatalf("expected revocations to be pending, got %+v", m)
+	}
+
+	if !m.RecoveryKey {
+		t.Fatal("expected a recovery key to be present")
+	}
+
+	// keys with a scheduled revocation are not yet revoked
+	if m.OldestKeyAge < int64((2 * time.Hour).Seconds()) {
+		t.Fatalf("expected the oldest key to be the original device keys, got an age of %ds", m.OldestKeyAge)
+	}
+}
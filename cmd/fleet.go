@@ -0,0 +1,21 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+var fleetCommand = &cobra.Command{
+	Use:   "fleet",
+	Short: "the fleet command",
+	Run: func(cmd *cobra.Command, args []string) {
+		fmt.Println("fleet called")
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(fleetCommand)
+}
@@ -0,0 +1,593 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joinself/self-go-sdk/pkg/ntp"
+	"github.com/joinself/self-go-sdk/pkg/siggraph"
+	"github.com/joinself/self-go-sdk/pkg/transport"
+	"github.com/spf13/cobra"
+)
+
+const (
+	// rotationPending the new key has been written to the sink, but may not have been submitted
+	rotationPending = "pending"
+	// rotationAdded the new key has been added, but has not been verified
+	rotationAdded = "added"
+	// rotationVerified the new key has been verified, but the old key has not been revoked
+	rotationVerified = "verified"
+	// rotationComplete the new key has been verified and the old key revoked
+	rotationComplete = "rotated"
+	// rotationFailed the rotation failed
+	rotationFailed = "failed"
+
+	// sinkExecPrefix the prefix of a key sink that runs a command
+	sinkExecPrefix = "exec:"
+)
+
+var (
+	keySink        string
+	canarySize     int
+	waveSize       int
+	waveDelay      time.Duration
+	revocationWait time.Duration
+)
+
+// rotationState the progress of a fleet rotation, keyed by identity and the device being replaced
+type rotationState map[string]map[string]*deviceRotation
+
+// deviceRotation the progress of replacing a device with a new device and key
+type deviceRotation struct {
+	Status     string `json:"status"`
+	OldKID     string `json:"old_kid"`
+	DID        string `json:"did"`
+	KID        string `json:"kid"`
+	AddedAt    int64  `json:"added_at,omitempty"`
+	VerifiedAt int64  `json:"verified_at,omitempty"`
+	RotatedAt  int64  `json:"rotated_at,omitempty"`
+	RevokesAt  int64  `json:"revokes_at,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+var fleetRotateCommand = &cobra.Command{
+	Use:   "rotate",
+	Short: "rotates the device keys of every identity in an inventory",
+	Long:  "rotates the device keys of every identity in an inventory in waves, starting with a canary wave. Each device is replaced by a new device with a new key, which is written to the key sink before it is submitted, and is verified to authenticate against the api before the old device's key is revoked. The rotation halts on the first failure, and can be resumed using the state file",
+	Run: func(cmd *cobra.Command, args []string) {
+		if inventoryFile == "" {
+			check(errors.New("you must specify an inventory of identities [--identities]"))
+		}
+
+		if stateFile == "" {
+			check(errors.New("you must specify a state file to record the progress of the rotation [--state]"))
+		}
+
+		if canarySize < 1 || waveSize < 1 {
+			check(errors.New("the canary and wave sizes must be at least 1"))
+		}
+
+		inv := mustLoadInventory()
+
+		if keySink == "" {
+			keySink = inv.KeySink
+		}
+
+		if keySink == "" && !dryRun {
+			check(errors.New("you must specify a key sink to write new keys to [--sink, key_sink]"))
+		}
+
+		state := make(rotationState)
+
+		data, err := os.ReadFile(stateFile)
+		if err == nil {
+			err = json.Unmarshal(data, &state)
+		}
+
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			check(fmt.Errorf("failed to load state file: %w", err))
+		}
+
+		for n, wave := range rotationWaves(inv.Identities) {
+			name := fmt.Sprintf("wave %d", n)
+			if n == 0 {
+				name = "canary wave"
+			}
+
+			progressf("\n%s: %s\n", name, strings.Join(identityIDs(wave), ", "))
+
+			if n > 0 && waveDelay > 0 && !dryRun {
+				progressf("  waiting %s before starting wave\n", waveDelay)
+				if !sleep(cmd.Context(), waveDelay) {
+					break
+				}
+			}
+
+			for _, i := range wave {
+				err := rotateFleetIdentity(cmd.Context(), i, state)
+				if err != nil {
+					errorf("\nrotation halted, resume it by running the command again with the same state file\n")
+					check(fmt.Errorf("failed to rotate identity '%s': %w", i.SelfID, err))
+				}
+			}
+		}
+	},
+}
+
+func init() {
+	fleetCommand.AddCommand(fleetRotateCommand)
+	fleetRotateCommand.Flags().StringVarP(&secretKey, "secret-key", "s", "", "Device secret key, for identities without a key reference")
+	fleetRotateCommand.Flags().StringVarP(&inventoryFile, "identities", "I", "", "Inventory file of identities to rotate device keys for")
+	fleetRotateCommand.Flags().StringVarP(&stateFile, "state", "S", "", "File to record the progress of the rotation to")
+	fleetRotateCommand.Flags().StringVarP(&keySink, "sink", "k", "", "Directory or 'exec:<command>' to write new keys to, overrides the inventories key_sink")
+	fleetRotateCommand.Flags().IntVar(&canarySize, "canary", 1, "Number of identities to rotate in the canary wave")
+	fleetRotateCommand.Flags().IntVarP(&waveSize, "wave-size", "w", 5, "Number of identities to rotate in each wave after the canary")
+	fleetRotateCommand.Flags().DurationVar(&waveDelay, "wave-delay", 0, "Time to wait between waves")
+	fleetRotateCommand.Flags().DurationVar(&revocationWait, "revocation-delay", 10*time.Minute, "Time after a new key is verified before the old key's revocation takes effect")
+	fleetRotateCommand.Flags().BoolVarP(&dryRun, "dry-run", "n", false, "Show the devices that would be rotated without rotating them")
+}
+
+// rotationWaves splits identities into a canary wave, followed by waves of '--wave-size'
+func rotationWaves(identities []inventoryIdentity) [][]inventoryIdentity {
+	var waves [][]inventoryIdentity
+
+	size := canarySize
+
+	for len(identities) > 0 {
+		if size > len(identities) {
+			size = len(identities)
+		}
+
+		waves = append(waves, identities[:size])
+		identities = identities[size:]
+		size = waveSize
+	}
+
+	return waves
+}
+
+// rotateFleetIdentity rotates the keys of all devices of an identity that have a valid
+// key, skipping devices that have already been rotated. The device using the signing
+// key is rotated last, so that the other devices are rotated with a key that is valid
+func rotateFleetIdentity(ctx context.Context, i inventoryIdentity, state rotationState) error {
+	sk, err := i.resolveKey()
+	if err != nil {
+		return err
+	}
+
+	// a previous attempt may have rotated the signing key
+	sk, err = currentFleetKey(i.SelfID, sk, state)
+	if err != nil {
+		return err
+	}
+
+	client := rest(ctx, i.SelfID, sk)
+
+	identity, sg, err := fetchIdentity(client, i.SelfID)
+	if err != nil {
+		return err
+	}
+
+	keys, err := keyHistory(identity.History, sg)
+	if err != nil {
+		return err
+	}
+
+	if state[i.SelfID] == nil {
+		state[i.SelfID] = make(map[string]*deviceRotation)
+	}
+
+	var dids []string
+	var signer string
+
+	now := time.Now()
+
+	for _, k := range keys {
+		if k.Type != siggraph.TypeDeviceKey || k.stateAt(now) != keyStateValid {
+			continue
+		}
+
+		if k.KID == sk.kid {
+			signer = k.DID
+		}
+
+		r := state[i.SelfID][k.DID]
+		if r != nil && r.Status == rotationComplete || replacesDevice(state[i.SelfID], k.DID) || contains(dids, k.DID) {
+			continue
+		}
+
+		dids = append(dids, k.DID)
+	}
+
+	for n, did := range dids {
+		if did == signer {
+			dids = append(append(dids[:n:n], dids[n+1:]...), signer)
+			break
+		}
+	}
+
+	for _, did := range dids {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		message := fmt.Sprintf("rotating %s device %s", i.SelfID, did)
+
+		if dryRun {
+			fmt.Printf("  %s (dry run)\n", message)
+			continue
+		}
+
+		done := make(chan error)
+
+		go log(message, done)
+
+		nk, err := rotateFleetDevice(ctx, client, i.SelfID, did, sk, state)
+		done <- err
+
+		serr := saveRotationState(state)
+		if serr != nil {
+			return serr
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if did != signer {
+			continue
+		}
+
+		r := state[i.SelfID][did]
+
+		err = i.updateKey(nk)
+		if err != nil {
+			spinners.Wait()
+			errorf("  update the key reference for %s with its new key %s before its old key is revoked at %s: %s\n", i.SelfID, r.KID, time.Unix(r.RevokesAt, 0).UTC().Format(time.RFC3339), err.Error())
+		}
+	}
+
+	return nil
+}
+
+// rotateFleetDevice replaces a device with a new device that has a new key, returning
+// the new key if it is known. A device can only have one active key on the signature
+// graph, so the new key is added for a new device. The new key is written to the key
+// sink before it is added, and is then verified to authenticate against the api. Only
+// once it has been verified is the old device's key revoked, in a separate operation
+// that takes effect after '--revocation-delay'
+func rotateFleetDevice(ctx context.Context, client *transport.Rest, selfID, did string, sk *key, state rotationState) (*key, error) {
+	_, sg, err := fetchIdentity(client, selfID)
+	if err != nil {
+		return nil, err
+	}
+
+	okid, err := sg.GetKeyID(did)
+	if err != nil {
+		return nil, err
+	}
+
+	var nk *key
+
+	r := state[selfID][did]
+
+	// a previous attempt may have added the new key before failing
+	if r == nil || r.OldKID != okid || !r.added(sg) {
+		r = &deviceRotation{
+			Status: rotationPending,
+			OldKID: okid,
+			DID:    strconv.Itoa(len(sg.Devices()) + 1),
+			KID:    strconv.Itoa(len(sg.Keys()) + 1),
+		}
+
+		state[selfID][did] = r
+
+		nk = generateKey(keyTypeSecret, r.KID)
+
+		// persist the new key before it is added to the identity
+		err = writeKey(keySink, selfID, r.DID, nk)
+		if err != nil {
+			return nil, r.fail(fmt.Errorf("failed to write key to sink: %w", err))
+		}
+
+		err = saveRotationState(state)
+		if err != nil {
+			return nil, err
+		}
+
+		err = addFleetKey(ctx, client, selfID, sk, nk, r)
+		if err != nil {
+			return nil, r.fail(err)
+		}
+
+		err = saveRotationState(state)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if r.VerifiedAt == 0 {
+		if nk == nil {
+			nk, err = readKey(keySink, selfID, r.DID)
+			if err != nil {
+				return nil, r.fail(fmt.Errorf("the new key from a previous attempt has been added, but was not verified. Verify the key written to the key sink and set its 'verified_at' in the state file to continue: %w", err))
+			}
+		}
+
+		err = verifyFleetKey(ctx, selfID, nk, r)
+		if err != nil {
+			return nil, r.fail(err)
+		}
+
+		err = saveRotationState(state)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = revokeFleetKey(ctx, client, selfID, did, sk, r)
+	if err != nil {
+		return nil, r.fail(err)
+	}
+
+	if nk == nil {
+		// the key may have been verified by a previous attempt
+		nk, _ = readKey(keySink, selfID, r.DID)
+	}
+
+	return nk, nil
+}
+
+// addFleetKey adds a new key for the new device replacing an old device
+func addFleetKey(ctx context.Context, client *transport.Rest, selfID string, sk, nk *key, r *deviceRotation) error {
+	actions := []siggraph.Action{
+		{
+			KID:    nk.kid,
+			DID:    r.DID,
+			Type:   siggraph.TypeDeviceKey,
+			Action: siggraph.ActionKeyAdd,
+			Key:    enc.EncodeToString(nk.publicKey()),
+		},
+	}
+
+	now, err := postFleetOperation(ctx, client, selfID, actions, sk)
+	if err != nil {
+		return err
+	}
+
+	r.Status = rotationAdded
+	r.AddedAt = now.Unix()
+
+	return nil
+}
+
+// verifyFleetKey checks a new key can authenticate against the api before the
+// old key is revoked. The check is made even if the command has been
+// interrupted, as the new key has already been added
+func verifyFleetKey(ctx context.Context, selfID string, nk *key, r *deviceRotation) error {
+	client, err := newRest(detach(ctx), selfID, nk)
+	if err == nil {
+		_, err = client.Get("/v1/identities/" + selfID)
+	}
+
+	if err != nil {
+		return fmt.Errorf("new key %s failed to authenticate, the old key %s has not been revoked: %w", nk.kid, r.OldKID, err)
+	}
+
+	r.Status = rotationVerified
+	r.VerifiedAt = time.Now().Unix()
+	r.Error = ""
+
+	return nil
+}
+
+// revokeFleetKey revokes the old device's key once the new key has been verified,
+// unless a previous attempt has already revoked it. The revocation takes effect
+// after '--revocation-delay', giving time for the new key to be deployed
+func revokeFleetKey(ctx context.Context, client *transport.Rest, selfID, did string, sk *key, r *deviceRotation) error {
+	_, sg, err := fetchIdentity(client, selfID)
+	if err != nil {
+		return err
+	}
+
+	ra, err := sg.RevokedAt(r.OldKID)
+	if err != nil {
+		return err
+	}
+
+	r.RevokesAt = revokedAt(ra)
+
+	if r.RevokesAt == 0 {
+		actions := []siggraph.Action{
+			{
+				KID:    r.OldKID,
+				DID:    did,
+				Type:   siggraph.TypeDeviceKey,
+				Action: siggraph.ActionKeyRevoke,
+			},
+		}
+
+		now, err := postFleetOperation(ctx, client, selfID, actions, sk)
+		if err != nil {
+			return err
+		}
+
+		r.RevokesAt = now.Add(revocationWait).Unix()
+	}
+
+	r.Status = rotationComplete
+	r.RotatedAt = time.Now().Unix()
+	r.Error = ""
+
+	return nil
+}
+
+// postFleetOperation signs and submits an operation, returning its timestamp. The
+// actions take effect from the operation's timestamp, with revocations delayed
+// by '--revocation-delay'
+func postFleetOperation(ctx context.Context, client *transport.Rest, selfID string, actions []siggraph.Action, sk *key) (time.Time, error) {
+	identity, sg, err := fetchIdentity(client, selfID)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	// operations must have a later timestamp than the previous operation
+	last, err := siggraph.ParseOperation(identity.History[len(identity.History)-1])
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	for ntp.TimeFunc().Unix() <= seconds(last.Timestamp) {
+		if !sleep(ctx, 100*time.Millisecond) {
+			return time.Time{}, ctx.Err()
+		}
+	}
+
+	now := ntp.TimeFunc()
+
+	for n := range actions {
+		actions[n].EffectiveFrom = now.Unix()
+
+		if actions[n].Action == siggraph.ActionKeyRevoke {
+			actions[n].EffectiveFrom = now.Add(revocationWait).Unix()
+		}
+	}
+
+	operation, err := signOperation(sg, actions, sk, now)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	err = executeOperation(selfID, sg, operation)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	_, err = client.Post("/v1/identities/"+selfID+"/history", "application/json", operation)
+
+	return now, err
+}
+
+// added returns true if the rotation's new key has been added to the signature graph
+func (r *deviceRotation) added(sg *siggraph.SignatureGraph) bool {
+	kid, err := sg.GetKeyID(r.DID)
+	return err == nil && kid == r.KID
+}
+
+// replacesDevice returns true if a device was added to replace one of the rotated devices
+func replacesDevice(rotations map[string]*deviceRotation, did string) bool {
+	for _, r := range rotations {
+		if r.DID == did {
+			return true
+		}
+	}
+
+	return false
+}
+
+// currentFleetKey returns the new key for a signing key that has been rotated by a
+// previous attempt, where the identity's key reference has not been updated
+func currentFleetKey(selfID string, sk *key, state rotationState) (*key, error) {
+	for _, r := range state[selfID] {
+		if r.OldKID != sk.kid || r.VerifiedAt == 0 {
+			continue
+		}
+
+		nk, err := readKey(keySink, selfID, r.DID)
+		if err != nil {
+			return nil, fmt.Errorf("the identity's key %s has been rotated, update its key reference with the new key %s written to the key sink: %w", sk.kid, r.KID, err)
+		}
+
+		return nk, nil
+	}
+
+	return sk, nil
+}
+
+// fail marks a rotation as failed
+func (r *deviceRotation) fail(err error) error {
+	r.Status = rotationFailed
+	r.Error = err.Error()
+	return err
+}
+
+// readKey reads a secret key back from a directory key sink. Keys
+// written to a command can't be read back
+func readKey(sink, selfID, did string) (*key, error) {
+	if strings.HasPrefix(sink, sinkExecPrefix) {
+		return nil, errors.New("keys can't be read back from an 'exec:' key sink")
+	}
+
+	data, err := os.ReadFile(filepath.Join(sink, selfID, did+".key"))
+	if err != nil {
+		return nil, err
+	}
+
+	return parseSecretKey(string(data), keyTypeSecret, "new key")
+}
+
+// writeKey writes a secret key to a key sink. If the sink is prefixed with 'exec:',
+// the command is run with the key on stdin, otherwise the key is written to the
+// file '<sink>/<selfID>/<deviceID>.key'
+func writeKey(sink, selfID, did string, k *key) error {
+	if strings.HasPrefix(sink, sinkExecPrefix) {
+		var stderr bytes.Buffer
+
+		c := exec.Command("sh", "-c", strings.TrimPrefix(sink, sinkExecPrefix))
+		c.Stdin = strings.NewReader(k.String() + "\n")
+		c.Stderr = &stderr
+		c.Env = append(os.Environ(), "SELF_ID="+selfID, "SELF_DEVICE_ID="+did, "SELF_KID="+k.kid)
+
+		err := c.Run()
+		if err != nil {
+			return fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+		}
+
+		return nil
+	}
+
+	dir := filepath.Join(sink, selfID)
+
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(dir, did+".key"), []byte(k.String()+"\n"), 0600)
+}
+
+// saveRotationState writes the rotation state to the state file
+func saveRotationState(state rotationState) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	err = os.WriteFile(stateFile, data, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to save state file: %w", err)
+	}
+
+	return nil
+}
+
+func identityIDs(identities []inventoryIdentity) []string {
+	var ids []string
+
+	for _, i := range identities {
+		ids = append(ids, i.SelfID)
+	}
+
+	return ids
+}
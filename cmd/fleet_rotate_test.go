@@ -0,0 +1,295 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package cmd
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/joinself/self-go-sdk/pkg/ntp"
+	"github.com/joinself/self-go-sdk/pkg/siggraph"
+)
+
+// testFleetIdentity a stand-in api for an identity being rotated, that records the
+// keys requests for the identity are authenticated with, and can reject a key
+type testFleetIdentity struct {
+	*testIdentity
+	reject string
+	kids   []string
+}
+
+func (f *testFleetIdentity) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	kid := tokenKID(r)
+
+	if r.Method == http.MethodGet {
+		f.kids = append(f.kids, kid)
+	}
+
+	if kid == f.reject {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	f.testIdentity.ServeHTTP(w, r)
+}
+
+// tokenKID returns the kid of the key a request's bearer token was signed with
+func tokenKID(r *http.Request) string {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+	data, err := base64.RawURLEncoding.DecodeString(strings.Split(token, ".")[0])
+	if err != nil {
+		return ""
+	}
+
+	var header struct {
+		KID string `json:"kid"`
+	}
+
+	json.Unmarshal(data, &header)
+
+	return header.KID
+}
+
+// setFleetRotation sets the key sink and state file of a rotation for the duration of a
+// test, and signs operations with a clock that advances a second each time it is read,
+// so that each operation has a later timestamp than the last without waiting
+func setFleetRotation(t *testing.T) {
+	t.Helper()
+
+	previousSink, previousState, previousWait := keySink, stateFile, revocationWait
+	t.Cleanup(func() { keySink, stateFile, revocationWait = previousSink, previousState, previousWait })
+
+	keySink = t.TempDir()
+	stateFile = filepath.Join(t.TempDir(), "rotation.json")
+	revocationWait = 10 * time.Minute
+
+	timeFunc := ntp.TimeFunc
+	t.Cleanup(func() { ntp.TimeFunc = timeFunc })
+
+	var mu sync.Mutex
+
+	clock := time.Now().Add(-10 * time.Minute)
+
+	ntp.TimeFunc = func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+
+		clock = clock.Add(time.Second)
+
+		return clock
+	}
+}
+
+// historyActions formats the actions of every operation after the first
+func historyActions(t *testing.T, history []json.RawMessage) string {
+	t.Helper()
+
+	var operations []string
+
+	for _, operation := range history[1:] {
+		op, err := siggraph.ParseOperation(operation)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var actions []string
+
+		for _, a := range op.Actions {
+			actions = append(actions, fmt.Sprintf("%s %s %s", a.Action, a.KID, a.DID))
+		}
+
+		operations = append(operations, strings.Join(actions, ","))
+	}
+
+	return strings.Join(operations, "\n")
+}
+
+// loadRotationState reads the rotation state back from the state file
+func loadRotationState(t *testing.T) rotationState {
+	t.Helper()
+
+	data, err := os.ReadFile(stateFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	state := make(rotationState)
+
+	err = json.Unmarshal(data, &state)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return state
+}
+
+func TestRotateFleetIdentity(t *testing.T) {
+	ti := newTestIdentity(t, "app", time.Now().Add(-time.Hour), "1", "2")
+	f := &testFleetIdentity{testIdentity: ti}
+
+	testAPI(t, f)
+	setFleetRotation(t)
+
+	keyFile := filepath.Join(t.TempDir(), "app.key")
+
+	err := os.WriteFile(keyFile, []byte(ti.keys["1"].String()), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	i := inventoryIdentity{SelfID: "app", SecretKeyFile: keyFile}
+
+	err = rotateFleetIdentity(context.Background(), i, make(rotationState))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// each device is replaced by a new device, and its old key is only revoked
+	// in a separate operation once the new key has been verified. The signing
+	// device is rotated last
+	expected := strings.Join([]string{
+		"key.add 4 3",
+		"key.revoke 2 2",
+		"key.add 5 4",
+		"key.revoke 1 1",
+	}, "\n")
+
+	if historyActions(t, ti.history) != expected {
+		t.Fatalf("expected operations:\n%s\ngot:\n%s", expected, historyActions(t, ti.history))
+	}
+
+	if !contains(f.kids, "4") || !contains(f.kids, "5") {
+		t.Fatalf("expected the new keys to be verified against the api, requests were authenticated with %v", f.kids)
+	}
+
+	keys, err := keyHistory(ti.history, ti.graph(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	state := loadRotationState(t)
+
+	for did, expected := range map[string]deviceRotation{
+		"2": {Status: rotationComplete, OldKID: "2", DID: "3", KID: "4"},
+		"1": {Status: rotationComplete, OldKID: "1", DID: "4", KID: "5"},
+	} {
+		r := state["app"][did]
+		if r == nil {
+			t.Fatalf("expected the rotation of device %s to be recorded in the state file", did)
+		}
+
+		if r.Status != expected.Status || r.OldKID != expected.OldKID || r.DID != expected.DID || r.KID != expected.KID {
+			t.Fatalf("expected device %s to be rotated to %+v, got %+v", did, expected, r)
+		}
+
+		if r.AddedAt == 0 || r.VerifiedAt == 0 || r.RotatedAt == 0 {
+			t.Fatalf("expected the stages of rotating device %s to be recorded, got %+v", did, r)
+		}
+
+		// the old key remains valid until the revocation delay has passed
+		if findKey(keys, r.OldKID).RevokedAt != r.RevokesAt || r.RevokesAt-r.AddedAt < int64(revocationWait.Seconds()) {
+			t.Fatalf("expected key %s to be revoked at %d, revoked at %d", r.OldKID, r.RevokesAt, findKey(keys, r.OldKID).RevokedAt)
+		}
+
+		nk, err := readKey(keySink, "app", r.DID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if nk.kid != r.KID {
+			t.Fatalf("expected key %s to be written to the key sink, got %s", r.KID, nk.kid)
+		}
+	}
+
+	// the identity's key reference is updated with the signing device's new key
+	sk, err := i.resolveKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if sk.kid != "5" {
+		t.Fatalf("expected the key file to be updated with key 5, got %s", sk.kid)
+	}
+}
+
+func TestRotateFleetIdentityResume(t *testing.T) {
+	ti := newTestIdentity(t, "app", time.Now().Add(-time.Hour), "1", "2")
+	f := &testFleetIdentity{testIdentity: ti, reject: "4"}
+
+	testAPI(t, f)
+	setFleetRotation(t)
+
+	// the key is referenced inline, so can't be updated when it is rotated
+	i := inventoryIdentity{SelfID: "app", SecretKey: ti.keys["1"].String()}
+
+	// the first device's new key fails to authenticate
+	err := rotateFleetIdentity(context.Background(), i, make(rotationState))
+	if err == nil {
+		t.Fatal("expected the rotation to fail")
+	}
+
+	if historyActions(t, ti.history) != "key.add 4 3" {
+		t.Fatalf("expected only the new key to be added, got:\n%s", historyActions(t, ti.history))
+	}
+
+	state := loadRotationState(t)
+
+	r := state["app"]["2"]
+	if r == nil || r.Status != rotationFailed || r.VerifiedAt != 0 || r.Error == "" {
+		t.Fatalf("expected the failed verification to be recorded in the state file, got %+v", r)
+	}
+
+	// resuming verifies the key that was added, instead of adding another
+	f.reject = ""
+
+	err = rotateFleetIdentity(context.Background(), i, state)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := strings.Join([]string{
+		"key.add 4 3",
+		"key.revoke 2 2",
+		"key.add 5 4",
+		"key.revoke 1 1",
+	}, "\n")
+
+	if historyActions(t, ti.history) != expected {
+		t.Fatalf("expected operations:\n%s\ngot:\n%s", expected, historyActions(t, ti.history))
+	}
+
+	state = loadRotationState(t)
+
+	for _, did := range []string{"1", "2"} {
+		if state["app"][did].Status != rotationComplete {
+			t.Fatalf("expected device %s to be rotated, got %+v", did, state["app"][did])
+		}
+	}
+
+	// resuming a completed rotation authenticates with the signing device's
+	// new key from the key sink, as the inline key reference was not updated
+	f.kids = nil
+
+	err = rotateFleetIdentity(context.Background(), i, state)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ti.history) != 5 {
+		t.Fatalf("expected no further operations, history has %d operations", len(ti.history))
+	}
+
+	if len(f.kids) < 1 || f.kids[0] != "5" {
+		t.Fatalf("expected requests to be authenticated with key 5, got %v", f.kids)
+	}
+}
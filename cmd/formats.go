@@ -0,0 +1,288 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package cmd
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/square/go-jose"
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	formatJWK     = "jwk"
+	formatPEM     = "pem"
+	formatOpenSSH = "openssh"
+)
+
+var (
+	errUnknownFormat = errors.New("key format must be one of [jwk, pem, openssh]")
+	errNotEd25519    = errors.New("key is not an ed25519 key")
+	errNoPEMBlock    = errors.New("no pem encoded key found")
+)
+
+// exportKey encodes a key in a standard format. If public is true,
+// only the public half of a secret key is exported
+func exportKey(k *key, format string, public bool) ([]byte, error) {
+	if public || !k.isSecret() {
+		return exportPublicKey(k, format)
+	}
+
+	switch format {
+	case formatJWK:
+		return json.MarshalIndent(jwk(k, k.privateKey()), "", "  ")
+	case formatPEM:
+		der, err := x509.MarshalPKCS8PrivateKey(k.privateKey())
+		if err != nil {
+			return nil, err
+		}
+
+		return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+	case formatOpenSSH:
+		return marshalOpenSSHPrivateKey(k.privateKey(), sshComment(k))
+	}
+
+	return nil, errUnknownFormat
+}
+
+func exportPublicKey(k *key, format string) ([]byte, error) {
+	switch format {
+	case formatJWK:
+		return json.MarshalIndent(jwk(k, k.publicKey()), "", "  ")
+	case formatPEM:
+		der, err := x509.MarshalPKIXPublicKey(k.publicKey())
+		if err != nil {
+			return nil, err
+		}
+
+		return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), nil
+	case formatOpenSSH:
+		pk, err := ssh.NewPublicKey(k.publicKey())
+		if err != nil {
+			return nil, err
+		}
+
+		ak := bytes.TrimSpace(ssh.MarshalAuthorizedKey(pk))
+
+		return append(ak, []byte(" "+sshComment(k)+"\n")...), nil
+	}
+
+	return nil, errUnknownFormat
+}
+
+// importKey decodes a key from a standard format, returning a key
+// of the given type if the imported key is a secret key
+func importKey(data []byte, format, typ string) (*key, error) {
+	var k interface{}
+	var kid string
+	var err error
+
+	switch format {
+	case formatJWK:
+		var jk jose.JSONWebKey
+
+		err = json.Unmarshal(data, &jk)
+		if err != nil {
+			return nil, err
+		}
+
+		k = jk.Key
+		kid = jk.KeyID
+	case formatPEM:
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return nil, errNoPEMBlock
+		}
+
+		switch block.Type {
+		case "PRIVATE KEY":
+			k, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+		case "PUBLIC KEY":
+			k, err = x509.ParsePKIXPublicKey(block.Bytes)
+		default:
+			return nil, fmt.Errorf("unsupported pem block type '%s'", block.Type)
+		}
+	case formatOpenSSH:
+		if bytes.Contains(data, []byte("PRIVATE KEY-----")) {
+			k, err = ssh.ParseRawPrivateKey(data)
+			if err == nil {
+				kid = sshKID(openSSHComment(data))
+			}
+			break
+		}
+
+		var pk ssh.PublicKey
+		var comment string
+
+		pk, comment, _, _, err = ssh.ParseAuthorizedKey(data)
+		if err != nil {
+			return nil, err
+		}
+
+		cpk, ok := pk.(ssh.CryptoPublicKey)
+		if !ok {
+			return nil, errNotEd25519
+		}
+
+		k = cpk.CryptoPublicKey()
+		kid = sshKID(comment)
+	default:
+		return nil, errUnknownFormat
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	switch ek := k.(type) {
+	case ed25519.PrivateKey:
+		return newKey(typ, kid, ek.Seed()), nil
+	case *ed25519.PrivateKey:
+		return newKey(typ, kid, ek.Seed()), nil
+	case ed25519.PublicKey:
+		return newKey(keyTypePublic, kid, ek), nil
+	}
+
+	return nil, errNotEd25519
+}
+
+// jwk builds a json web key with the self key identifier embedded
+func jwk(k *key, material interface{}) jose.JSONWebKey {
+	return jose.JSONWebKey{
+		Key:       material,
+		KeyID:     k.kid,
+		Algorithm: string(jose.EdDSA),
+		Use:       "sig",
+	}
+}
+
+func sshComment(k *key) string {
+	if k.kid == "" {
+		return "self"
+	}
+
+	return "self-kid:" + k.kid
+}
+
+// sshKID gets the key identifier from an openssh key's comment
+func sshKID(comment string) string {
+	if !strings.HasPrefix(comment, "self-kid:") {
+		return ""
+	}
+
+	return strings.TrimPrefix(comment, "self-kid:")
+}
+
+// openSSHComment gets the comment of an unencrypted openssh-key-v1 private
+// key, which is not returned when the key is parsed by the ssh package
+func openSSHComment(data []byte) string {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "OPENSSH PRIVATE KEY" {
+		return ""
+	}
+
+	magic := []byte("openssh-key-v1\x00")
+	if !bytes.HasPrefix(block.Bytes, magic) {
+		return ""
+	}
+
+	var w struct {
+		CipherName   string
+		KdfName      string
+		KdfOpts      string
+		NumKeys      uint32
+		PubKey       []byte
+		PrivKeyBlock []byte
+		Rest         []byte `ssh:"rest"`
+	}
+
+	err := ssh.Unmarshal(block.Bytes[len(magic):], &w)
+	if err != nil || w.CipherName != "none" {
+		return ""
+	}
+
+	var pk1 struct {
+		Check1  uint32
+		Check2  uint32
+		Keytype string
+		Pub     []byte
+		Priv    []byte
+		Comment string
+		Pad     []byte `ssh:"rest"`
+	}
+
+	err = ssh.Unmarshal(w.PrivKeyBlock, &pk1)
+	if err != nil || pk1.Keytype != ssh.KeyAlgoED25519 {
+		return ""
+	}
+
+	return pk1.Comment
+}
+
+// marshalOpenSSHPrivateKey encodes an unencrypted ed25519 private key
+// in the openssh-key-v1 format. See
+// https://github.com/openssh/openssh-portable/blob/master/PROTOCOL.key
+func marshalOpenSSHPrivateKey(sk ed25519.PrivateKey, comment string) ([]byte, error) {
+	pk, err := ssh.NewPublicKey(sk.Public())
+	if err != nil {
+		return nil, err
+	}
+
+	var check [4]byte
+
+	_, err = rand.Read(check[:])
+	if err != nil {
+		return nil, err
+	}
+
+	pk1 := struct {
+		Check1  uint32
+		Check2  uint32
+		Keytype string
+		Pub     []byte
+		Priv    []byte
+		Comment string
+	}{
+		Check1:  binary.BigEndian.Uint32(check[:]),
+		Check2:  binary.BigEndian.Uint32(check[:]),
+		Keytype: ssh.KeyAlgoED25519,
+		Pub:     sk.Public().(ed25519.PublicKey),
+		Priv:    sk,
+		Comment: comment,
+	}
+
+	block := ssh.Marshal(pk1)
+
+	// pad the private key block to the cipher block size of 8
+	for i := 1; len(block)%8 != 0; i++ {
+		block = append(block, byte(i))
+	}
+
+	w := struct {
+		CipherName   string
+		KdfName      string
+		KdfOpts      string
+		NumKeys      uint32
+		PubKey       []byte
+		PrivKeyBlock []byte
+	}{
+		CipherName:   "none",
+		KdfName:      "none",
+		NumKeys:      1,
+		PubKey:       pk.Marshal(),
+		PrivKeyBlock: block,
+	}
+
+	data := append([]byte("openssh-key-v1\x00"), ssh.Marshal(w)...)
+
+	return pem.EncodeToMemory(&pem.Block{Type: "OPENSSH PRIVATE KEY", Bytes: data}), nil
+}
@@ -0,0 +1,87 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package cmd
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestExportImportKey(t *testing.T) {
+	sk := generateKey(keyTypeSecret, "3")
+	rk := generateKey(keyTypeRecovery, "4")
+
+	tests := []struct {
+		name   string
+		key    *key
+		format string
+		public bool
+		typ    string
+		kid    string
+	}{
+		{"jwk secret", sk, formatJWK, false, keyTypeSecret, "3"},
+		{"jwk public", sk, formatJWK, true, keyTypePublic, "3"},
+		{"jwk recovery", rk, formatJWK, false, keyTypeRecovery, "4"},
+		{"pem secret", sk, formatPEM, false, keyTypeSecret, ""},
+		{"pem public", sk, formatPEM, true, keyTypePublic, ""},
+		{"openssh secret", sk, formatOpenSSH, false, keyTypeSecret, "3"},
+		{"openssh public", sk, formatOpenSSH, true, keyTypePublic, "3"},
+		{"openssh recovery", rk, formatOpenSSH, false, keyTypeRecovery, "4"},
+		{"openssh without kid", newKey(keyTypeSecret, "", sk.data), formatOpenSSH, false, keyTypeSecret, ""},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			data, err := exportKey(tc.key, tc.format, tc.public)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			typ := tc.key.typ
+
+			k, err := importKey(data, tc.format, typ)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if k.typ != tc.typ {
+				t.Fatalf("expected type %s, got %s", tc.typ, k.typ)
+			}
+
+			if k.kid != tc.kid {
+				t.Fatalf("expected kid '%s', got '%s'", tc.kid, k.kid)
+			}
+
+			expected := tc.key.data
+			if tc.public {
+				expected = tc.key.publicKey()
+			}
+
+			if !bytes.Equal(k.data, expected) {
+				t.Fatal("imported key does not match the exported key")
+			}
+		})
+	}
+}
+
+func TestImportKeyErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   string
+		format string
+	}{
+		{"unknown format", "{}", "der"},
+		{"invalid jwk", "{", formatJWK},
+		{"no pem block", "not a key", formatPEM},
+		{"invalid openssh", "ssh-ed25519 not-base64", formatOpenSSH},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := importKey([]byte(tc.data), tc.format, keyTypeSecret)
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,333 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/joinself/self-go-sdk/pkg/siggraph"
+	"github.com/joinself/self-go-sdk/pkg/transport"
+	"golang.org/x/crypto/ed25519"
+)
+
+const (
+	// keyStateValid the key is valid
+	keyStateValid = "valid"
+	// keyStatePending the key has been added, but is not yet effective
+	keyStatePending = "pending"
+	// keyStateRevoked the key has been revoked
+	keyStateRevoked = "revoked"
+)
+
+// keyInfo describes a key that has been added to an identities signature graph
+type keyInfo struct {
+	KID             string            // id of the key
+	DID             string            // id of the device, if the key is a device key
+	Type            string            // type of the key [device.key, recovery.key]
+	PublicKey       ed25519.PublicKey // the public key
+	Sequence        int               // sequence of the operation that added the key
+	SignedBy        string            // id of the key that signed the operation that added the key
+	CreatedAt       int64             // timestamp of the operation that added the key
+	EffectiveFrom   int64             // when the add action takes effect from, 0 if not specified
+	RevokedAt       int64             // when the key was revoked, 0 if it has not been revoked
+	RevokedBy       string            // id of the key that signed the operation that revoked the key
+	RevokedSequence int               // sequence of the operation that revoked the key, 0 if it has not been revoked
+}
+
+// getIdentity gets an identity and loads its signature graph
+func getIdentity(client *transport.Rest, selfID string) (*Identity, *siggraph.SignatureGraph) {
+	done := make(chan error)
+
+	// get the identity history
+	go log("getting identity history", done)
+
+	identity, sg, err := fetchIdentity(client, selfID)
+	done <- err
+
+	if err != nil {
+		exit(1)
+	}
+
+	return identity, sg
+}
+
+// fetchIdentity gets an identity and loads its signature graph,
+// returning any error encountered
+func fetchIdentity(client *transport.Rest, selfID string) (*Identity, *siggraph.SignatureGraph, error) {
+	resp, err := client.Get("/v1/identities/" + selfID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var identity Identity
+
+	err = json.Unmarshal(resp, &identity)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sg, err := loadGraph(selfID, identity.History)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &identity, sg, nil
+}
+
+// fetchDevices gets the devices an identity advertises as available for receiving messages
+func fetchDevices(client *transport.Rest, selfID string) ([]string, error) {
+	resp, err := client.Get("/v1/identities/" + selfID + "/devices")
+	if err != nil {
+		return nil, err
+	}
+
+	var devices []string
+
+	err = json.Unmarshal(resp, &devices)
+	if err != nil {
+		return nil, err
+	}
+
+	return devices, nil
+}
+
+// loadIdentity loads an identities history from a file, and loads
+// its signature graph. The file may either contain an identity, as
+// returned by the api, or just an array of operations
+func loadIdentity(path string) (*Identity, *siggraph.SignatureGraph, error) {
+	data, err := readInput(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var identity Identity
+
+	if len(bytes.TrimSpace(data)) > 0 && bytes.TrimSpace(data)[0] == '[' {
+		err = json.Unmarshal(data, &identity.History)
+	} else {
+		err = json.Unmarshal(data, &identity)
+	}
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sg, err := loadGraph(identity.SelfID, identity.History)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &identity, sg, nil
+}
+
+// pointInTime evaluates an identities history at the sequence and time specified
+// by the '--at-sequence' and '--at' flags. If a sequence is specified, the history
+// is truncated to that operation and a new signature graph is loaded from it. If no
+// time is specified, the time of that operation is used, or the current time if no
+// sequence is specified
+func pointInTime(history []json.RawMessage, sg *siggraph.SignatureGraph) ([]json.RawMessage, *siggraph.SignatureGraph, time.Time, error) {
+	var err error
+
+	if atSequence >= 0 {
+		if atSequence >= len(history) {
+			return nil, nil, time.Time{}, fmt.Errorf("sequence %d does not exist, the identities latest sequence is %d", atSequence, len(history)-1)
+		}
+
+		history = history[:atSequence+1]
+
+		sg, err = siggraph.New(history)
+		if err != nil {
+			return nil, nil, time.Time{}, err
+		}
+	}
+
+	if atTime != "" {
+		at, err := parseTime(atTime)
+		return history, sg, at, err
+	}
+
+	if atSequence < 0 {
+		return history, sg, time.Now(), nil
+	}
+
+	op, err := siggraph.ParseOperation(history[len(history)-1])
+	if err != nil {
+		return nil, nil, time.Time{}, err
+	}
+
+	return history, sg, timestamp(op.Timestamp), nil
+}
+
+// keyHistory returns all keys added to the signature graph, in the
+// order they were added. All times are returned as unix timestamps
+func keyHistory(history []json.RawMessage, sg *siggraph.SignatureGraph) ([]*keyInfo, error) {
+	var keys []*keyInfo
+	var recoveries []*siggraph.Operation
+
+	for _, operation := range history {
+		op, err := siggraph.ParseOperation(operation)
+		if err != nil {
+			return nil, err
+		}
+
+		signer := findKey(keys, op.SignatureKeyID())
+		if signer != nil && signer.Type == siggraph.TypeRecoveryKey {
+			recoveries = append(recoveries, op)
+		}
+
+		for _, a := range op.Actions {
+			if a.Action == siggraph.ActionKeyRevoke {
+				k := findKey(keys, a.KID)
+				if k != nil {
+					k.RevokedSequence = op.Sequence
+					k.RevokedBy = op.SignatureKeyID()
+				}
+
+				continue
+			}
+
+			pk, err := enc.DecodeString(a.Key)
+			if err != nil {
+				return nil, err
+			}
+
+			ra, err := sg.RevokedAt(a.KID)
+			if err != nil {
+				return nil, err
+			}
+
+			keys = append(keys, &keyInfo{
+				KID:           a.KID,
+				DID:           a.DID,
+				Type:          a.Type,
+				PublicKey:     ed25519.PublicKey(pk),
+				Sequence:      op.Sequence,
+				SignedBy:      op.SignatureKeyID(),
+				CreatedAt:     seconds(op.Timestamp),
+				EffectiveFrom: seconds(a.EffectiveFrom),
+				RevokedAt:     revokedAt(ra),
+			})
+		}
+	}
+
+	// keys that were revoked without an explicit revocation were
+	// revoked by the first account recovery after they were added
+	for _, k := range keys {
+		if k.RevokedAt == 0 || k.RevokedSequence != 0 {
+			continue
+		}
+
+		for _, op := range recoveries {
+			if op.Sequence > k.Sequence {
+				k.RevokedSequence = op.Sequence
+				k.RevokedBy = op.SignatureKeyID()
+				break
+			}
+		}
+	}
+
+	return keys, nil
+}
+
+// validAt returns true if the key was valid at the given time. A key is valid from
+// when it was added, or when the add takes effect if later, until it was revoked
+func (k *keyInfo) validAt(t time.Time) bool {
+	from := k.CreatedAt
+	if k.EffectiveFrom > from {
+		from = k.EffectiveFrom
+	}
+
+	if t.Unix() < from {
+		return false
+	}
+
+	return k.RevokedAt == 0 || t.Unix() < k.RevokedAt
+}
+
+// stateAt returns the state of the key at the given time, or
+// an empty string if the key had not been added at that time
+func (k *keyInfo) stateAt(t time.Time) string {
+	switch {
+	case t.Unix() < k.CreatedAt:
+		return ""
+	case k.RevokedAt != 0 && t.Unix() >= k.RevokedAt:
+		return keyStateRevoked
+	case !k.validAt(t):
+		return keyStatePending
+	}
+
+	return keyStateValid
+}
+
+// revokedAsOf returns when the key was revoked, or 0 if the
+// key had not been revoked as of the given time
+func (k *keyInfo) revokedAsOf(t time.Time) int64 {
+	if k.RevokedAt == 0 || t.Unix() < k.RevokedAt {
+		return 0
+	}
+
+	return k.RevokedAt
+}
+
+// findKey finds a key by its identifier
+func findKey(keys []*keyInfo, kid string) *keyInfo {
+	for _, k := range keys {
+		if k.KID == kid {
+			return k
+		}
+	}
+
+	return nil
+}
+
+// revokedAt normalises the revocation time returned by the signature
+// graph, which returns the unix time of a zero time for unrevoked keys
+func revokedAt(ra int64) int64 {
+	if ra == (time.Time{}).Unix() {
+		return 0
+	}
+
+	return ra
+}
+
+// timestamp converts a signature graph timestamp, which may be in
+// seconds or milliseconds, to a time
+func timestamp(ts int64) time.Time {
+	if ts == 0 {
+		return time.Time{}
+	}
+
+	if ts > 1<<32-1 {
+		return time.UnixMilli(ts)
+	}
+
+	return time.Unix(ts, 0)
+}
+
+// seconds converts a signature graph timestamp to a unix timestamp in seconds
+func seconds(ts int64) int64 {
+	if ts == 0 {
+		return 0
+	}
+
+	return timestamp(ts).Unix()
+}
+
+// parseTime parses a time that is either RFC3339 formatted, or a unix timestamp
+func parseTime(s string) (time.Time, error) {
+	ts, err := strconv.ParseInt(s, 10, 64)
+	if err == nil {
+		return timestamp(ts), nil
+	}
+
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("time '%s' must be a unix timestamp or RFC3339 formatted", s)
+	}
+
+	return t, nil
+}
@@ -0,0 +1,403 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	requestTimeout time.Duration
+	maxRetries     int
+	caBundle       string
+	certPins       []string
+
+	httpClientOnce sync.Once
+	httpClient     *http.Client
+	httpClientErr  error
+)
+
+// retryTransport retries failed requests with exponential backoff. Idempotent
+// requests are retried on any error, history posts are only retried once it
+// is known the operation was not accepted, and other requests are only retried
+// if they were not received by the server
+type retryTransport struct {
+	next http.RoundTripper
+}
+
+func init() {
+	rootCmd.PersistentFlags().DurationVar(&requestTimeout, "timeout", 30*time.Second, "Timeout for each request to the api")
+	rootCmd.PersistentFlags().IntVar(&maxRetries, "retries", 3, "Number of times to retry a failed request to the api")
+	rootCmd.PersistentFlags().StringVar(&caBundle, "ca-bundle", "", "PEM file of additional certificate authorities to trust, i.e. for a TLS inspecting proxy. Overrides the environment's ca_bundle")
+	rootCmd.PersistentFlags().StringSliceVar(&certPins, "pin", nil, "Base64 encoded sha256 hash of a public key to pin the api's certificate chain to")
+}
+
+// apiClient returns the http client used to make requests to the api. Requests
+// are sent via the proxy specified by the 'HTTPS_PROXY' environment variable
+func apiClient() *http.Client {
+	client, err := newAPIClient()
+	check(err)
+
+	return client
+}
+
+// newAPIClient builds the http client used to make requests to the api,
+// returning any error with the network configuration
+func newAPIClient() (*http.Client, error) {
+	httpClientOnce.Do(func() {
+		tlsConfig, err := apiTLSConfig()
+		if err != nil {
+			httpClientErr = err
+			return
+		}
+
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.Proxy = http.ProxyFromEnvironment
+		transport.TLSClientConfig = tlsConfig
+
+		var next http.RoundTripper = transport
+
+		if debug {
+			next = &debugTransport{next: next}
+		}
+
+		next = &retryTransport{next: next}
+
+		if tracingEnabled() {
+			next = &tracingTransport{next: next}
+		}
+
+		httpClient = &http.Client{
+			Transport: next,
+		}
+	})
+
+	return httpClient, httpClientErr
+}
+
+// apiTLSConfig builds the tls config for connecting to the api, trusting any
+// additional certificate authorities and pinning the api's certificate chain
+func apiTLSConfig() (*tls.Config, error) {
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	env, err := currentEnvironment()
+	if err != nil {
+		return nil, err
+	}
+
+	bundle := caBundle
+	if bundle == "" {
+		bundle = env.CABundle
+	}
+
+	if bundle != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+
+		pem, err := os.ReadFile(bundle)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read ca bundle: %w", err)
+		}
+
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("ca bundle does not contain any valid certificates")
+		}
+
+		cfg.RootCAs = pool
+	}
+
+	if len(certPins) < 1 {
+		return cfg, nil
+	}
+
+	pins := make(map[string]struct{})
+
+	for _, p := range certPins {
+		pin, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(p, "sha256/"))
+		if err != nil || len(pin) != sha256.Size {
+			return nil, fmt.Errorf("certificate pin '%s' must be a base64 encoded sha256 hash", p)
+		}
+
+		pins[string(pin)] = struct{}{}
+	}
+
+	api, err := resolveAPIURL()
+	if err != nil {
+		return nil, err
+	}
+
+	u, err := url.Parse(api)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+		// only connections to the api are pinned
+		if cs.ServerName != u.Hostname() {
+			return nil
+		}
+
+		for _, cert := range cs.PeerCertificates {
+			h := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+
+			if _, ok := pins[string(h[:])]; ok {
+				return nil
+			}
+		}
+
+		return fmt.Errorf("certificate chain for %s does not match any pinned public key", cs.ServerName)
+	}
+
+	return cfg, nil
+}
+
+// RoundTrip sends a request, retrying it if it fails
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+
+	if req.Body != nil {
+		var err error
+
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for attempt := 0; ; attempt++ {
+		resp, err := t.attempt(req, body)
+
+		retry := t.retryable(req, resp, err)
+
+		// operations are chained to the previous operation, so can only be
+		// accepted once. If the outcome of posting one is unknown, only retry
+		// if it is known that it was not accepted
+		if !retry && unknownOutcome(resp, err) && req.Method == http.MethodPost && strings.HasSuffix(req.URL.Path, "/history") {
+			accepted, known := t.accepted(req, body)
+
+			switch {
+			case known && accepted:
+				if resp != nil {
+					resp.Body.Close()
+				}
+
+				return &http.Response{
+					Status:     "201 Created",
+					StatusCode: http.StatusCreated,
+					Header:     make(http.Header),
+					Body:       io.NopCloser(bytes.NewReader(nil)),
+					Request:    req,
+				}, nil
+			case known:
+				retry = true
+			}
+		}
+
+		if !retry || attempt >= maxRetries {
+			return resp, err
+		}
+
+		var wait time.Duration
+		var reason string
+
+		if resp != nil {
+			if s, perr := strconv.Atoi(resp.Header.Get("Retry-After")); perr == nil {
+				wait = time.Duration(s) * time.Second
+			}
+
+			reason = resp.Status
+			resp.Body.Close()
+		} else {
+			reason = err.Error()
+		}
+
+		wait = backoff(attempt, wait)
+
+		verbosef("  retrying %s %s in %s: %s\n", req.Method, req.URL.Path, wait.Round(time.Millisecond), reason)
+
+		if !sleep(req.Context(), wait) {
+			return nil, req.Context().Err()
+		}
+	}
+}
+
+// attempt sends a request once, with the configured request timeout
+func (t *retryTransport) attempt(req *http.Request, body []byte) (*http.Response, error) {
+	var ctx context.Context
+	var cancel context.CancelFunc
+
+	if requestTimeout > 0 {
+		ctx, cancel = context.WithTimeout(req.Context(), requestTimeout)
+	} else {
+		ctx, cancel = context.WithCancel(req.Context())
+	}
+
+	r := req.Clone(ctx)
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	r.ContentLength = int64(len(body))
+
+	if body == nil {
+		r.Body = nil
+	}
+
+	resp, err := t.next.RoundTrip(r)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	// the timeout also applies to reading the response body
+	resp.Body = &cancelBody{ReadCloser: resp.Body, cancel: cancel}
+
+	return resp, nil
+}
+
+// retryable determines if a request can safely be retried
+func (t *retryTransport) retryable(req *http.Request, resp *http.Response, err error) bool {
+	if req.Context().Err() != nil {
+		return false
+	}
+
+	// the server did not process the request
+	if resp != nil && (resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable) {
+		return true
+	}
+
+	if notSent(err) {
+		return true
+	}
+
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete:
+		return unknownOutcome(resp, err)
+	}
+
+	return false
+}
+
+// accepted checks if an operation posted to an identities history has been
+// accepted, and whether that could be determined
+func (t *retryTransport) accepted(req *http.Request, body []byte) (bool, bool) {
+	var operation struct {
+		Signature string `json:"signature"`
+	}
+
+	if json.Unmarshal(body, &operation) != nil || operation.Signature == "" {
+		return false, false
+	}
+
+	r := req.Clone(req.Context())
+	r.Method = http.MethodGet
+	r.Body = nil
+	r.ContentLength = 0
+	r.Header.Del("Content-Type")
+
+	resp, err := t.RoundTrip(r)
+	if err != nil {
+		return false, false
+	}
+
+	defer resp.Body.Close()
+
+	var history []struct {
+		Signature string `json:"signature"`
+	}
+
+	if resp.StatusCode != http.StatusOK || json.NewDecoder(resp.Body).Decode(&history) != nil {
+		return false, false
+	}
+
+	for _, op := range history {
+		if op.Signature == operation.Signature {
+			return true, true
+		}
+	}
+
+	return false, true
+}
+
+// unknownOutcome returns true if the request failed in a way where the
+// server may or may not have processed it
+func unknownOutcome(resp *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+
+	return resp.StatusCode == http.StatusBadGateway || resp.StatusCode == http.StatusGatewayTimeout
+}
+
+// notSent returns true if the error occurred before the request was sent
+func notSent(err error) bool {
+	var oerr *net.OpError
+
+	if errors.As(err, &oerr) {
+		return oerr.Op == "dial" || oerr.Op == "proxyconnect"
+	}
+
+	return false
+}
+
+// backoff returns the exponential backoff with jitter for an attempt,
+// or the time the server asked to wait if it is longer
+func backoff(attempt int, wait time.Duration) time.Duration {
+	d := 10 * time.Second
+
+	// later attempts wait the maximum, before the backoff overflows
+	if attempt < 6 {
+		d = 250 * time.Millisecond << attempt
+	}
+
+	d = d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+
+	if wait > d {
+		return wait
+	}
+
+	return d
+}
+
+// sleep waits for the duration, returning false if the context is cancelled
+func sleep(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
+
+// cancelBody cancels a requests context once its response body is closed
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	defer b.cancel()
+	return b.ReadCloser.Close()
+}
@@ -0,0 +1,261 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testHistory a stand-in for an identities history endpoint, that can fail
+// posts to it either before or after the operation has been accepted
+type testHistory struct {
+	mu         sync.Mutex
+	operations []json.RawMessage
+	posts      int
+	// respond returns the status to respond to a post with, and whether the operation is accepted
+	respond func(post int) (int, bool)
+	// delay how long to wait before responding to a post
+	delay time.Duration
+	// getStatus the status to respond to gets with, if not ok
+	getStatus int
+}
+
+func (h *testHistory) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mu.Lock()
+
+	switch r.Method {
+	case http.MethodGet:
+		if h.getStatus != 0 {
+			h.mu.Unlock()
+			w.WriteHeader(h.getStatus)
+			return
+		}
+
+		data, _ := json.Marshal(h.operations)
+		h.mu.Unlock()
+
+		w.Write(data)
+	case http.MethodPost:
+		h.posts++
+
+		data, _ := io.ReadAll(r.Body)
+
+		status, accept := h.respond(h.posts)
+		if accept {
+			h.operations = append(h.operations, json.RawMessage(data))
+		}
+
+		h.mu.Unlock()
+
+		time.Sleep(h.delay)
+
+		w.WriteHeader(status)
+	}
+}
+
+// setRetryPolicy sets the request timeout and retries for the duration of a test
+func setRetryPolicy(t *testing.T, timeout time.Duration, retries int) {
+	t.Helper()
+
+	previousTimeout, previousRetries := requestTimeout, maxRetries
+	t.Cleanup(func() { requestTimeout, maxRetries = previousTimeout, previousRetries })
+
+	requestTimeout, maxRetries = timeout, retries
+}
+
+func TestRetryHistoryPost(t *testing.T) {
+	tests := []struct {
+		name      string
+		respond   func(post int) (int, bool)
+		delay     time.Duration
+		getStatus int
+		status    int
+		posts     int
+		accepted  int
+	}{
+		{
+			name:     "accepted",
+			respond:  func(post int) (int, bool) { return http.StatusCreated, true },
+			status:   http.StatusCreated,
+			posts:    1,
+			accepted: 1,
+		},
+		{
+			name:     "accepted before a bad gateway",
+			respond:  func(post int) (int, bool) { return http.StatusBadGateway, true },
+			status:   http.StatusCreated,
+			posts:    1,
+			accepted: 1,
+		},
+		{
+			name:     "accepted before timing out",
+			respond:  func(post int) (int, bool) { return http.StatusCreated, true },
+			delay:    500 * time.Millisecond,
+			status:   http.StatusCreated,
+			posts:    1,
+			accepted: 1,
+		},
+		{
+			name: "not accepted before a bad gateway",
+			respond: func(post int) (int, bool) {
+				if post == 1 {
+					return http.StatusBadGateway, false
+				}
+				return http.StatusCreated, true
+			},
+			status:   http.StatusCreated,
+			posts:    2,
+			accepted: 1,
+		},
+		{
+			name:      "outcome unknown",
+			respond:   func(post int) (int, bool) { return http.StatusBadGateway, false },
+			getStatus: http.StatusInternalServerError,
+			status:    http.StatusBadGateway,
+			posts:     1,
+		},
+		{
+			name:     "rejected",
+			respond:  func(post int) (int, bool) { return http.StatusBadRequest, false },
+			status:   http.StatusBadRequest,
+			posts:    1,
+			accepted: 0,
+		},
+		{
+			name: "unavailable",
+			respond: func(post int) (int, bool) {
+				if post == 1 {
+					return http.StatusServiceUnavailable, false
+				}
+				return http.StatusCreated, true
+			},
+			status:   http.StatusCreated,
+			posts:    2,
+			accepted: 1,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			setRetryPolicy(t, 200*time.Millisecond, 2)
+
+			h := &testHistory{respond: tc.respond, delay: tc.delay, getStatus: tc.getStatus}
+
+			srv := httptest.NewServer(h)
+			defer srv.Close()
+
+			client := &http.Client{Transport: &retryTransport{next: http.DefaultTransport}}
+
+			operation := []byte(`{"payload":"e30","protected":"e30","signature":"` + tc.name + `"}`)
+
+			resp, err := client.Post(srv.URL+"/v1/identities/app/history", "application/json", bytes.NewReader(operation))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			resp.Body.Close()
+
+			if resp.StatusCode != tc.status {
+				t.Fatalf("expected status %d, got %d", tc.status, resp.StatusCode)
+			}
+
+			h.mu.Lock()
+			defer h.mu.Unlock()
+
+			if h.posts != tc.posts {
+				t.Fatalf("expected the operation to be posted %d times, was posted %d times", tc.posts, h.posts)
+			}
+
+			if len(h.operations) != tc.accepted {
+				t.Fatalf("expected %d operations to be accepted, got %d", tc.accepted, len(h.operations))
+			}
+		})
+	}
+}
+
+func TestRetryRequests(t *testing.T) {
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		status   int
+		attempts int
+	}{
+		{"get", http.MethodGet, "/v1/identities/app", http.StatusBadGateway, 3},
+		{"get rate limited", http.MethodGet, "/v1/identities/app", http.StatusTooManyRequests, 3},
+		{"get not found", http.MethodGet, "/v1/identities/app", http.StatusNotFound, 1},
+		{"post", http.MethodPost, "/v1/apps/app/devices", http.StatusBadGateway, 1},
+		{"post unavailable", http.MethodPost, "/v1/apps/app/devices", http.StatusServiceUnavailable, 3},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			setRetryPolicy(t, time.Second, 2)
+
+			var attempts int
+
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				attempts++
+				w.WriteHeader(tc.status)
+			}))
+
+			defer srv.Close()
+
+			client := &http.Client{Transport: &retryTransport{next: http.DefaultTransport}}
+
+			req, err := http.NewRequest(tc.method, srv.URL+tc.path, bytes.NewReader([]byte("{}")))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			resp.Body.Close()
+
+			if resp.StatusCode != tc.status {
+				t.Fatalf("expected status %d, got %d", tc.status, resp.StatusCode)
+			}
+
+			if attempts != tc.attempts {
+				t.Fatalf("expected %d attempts, got %d", tc.attempts, attempts)
+			}
+		})
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		attempt int
+		wait    time.Duration
+		min     time.Duration
+		max     time.Duration
+	}{
+		{0, 0, 125 * time.Millisecond, 250 * time.Millisecond},
+		{2, 0, 500 * time.Millisecond, time.Second},
+		{5, 0, 4 * time.Second, 8 * time.Second},
+		{6, 0, 5 * time.Second, 10 * time.Second},
+		{36, 0, 5 * time.Second, 10 * time.Second},
+		{64, 0, 5 * time.Second, 10 * time.Second},
+		{1000, 0, 5 * time.Second, 10 * time.Second},
+		{1, time.Minute, time.Minute, time.Minute},
+	}
+
+	for _, tc := range tests {
+		for n := 0; n < 100; n++ {
+			d := backoff(tc.attempt, tc.wait)
+			if d < tc.min || d > tc.max {
+				t.Fatalf("expected backoff for attempt %d to be between %s and %s, got %s", tc.attempt, tc.min, tc.max, d)
+			}
+		}
+	}
+}
@@ -0,0 +1,21 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+var identityCommand = &cobra.Command{
+	Use:   "identity",
+	Short: "the identity command",
+	Run: func(cmd *cobra.Command, args []string) {
+		fmt.Println("identity called")
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(identityCommand)
+}
@@ -0,0 +1,432 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joinself/self-go-sdk/pkg/siggraph"
+	"github.com/joinself/self-go-sdk/pkg/transport"
+	"github.com/olekukonko/tablewriter"
+	"github.com/spf13/cobra"
+)
+
+const (
+	severityCritical = "critical"
+	severityWarning  = "warning"
+	severityInfo     = "info"
+
+	auditFormatTable = "table"
+	auditFormatJSON  = "json"
+
+	// auditRuleKeyAge a valid device key is older than the maximum key age
+	auditRuleKeyAge = "key-age"
+	// auditRuleNoRecoveryKey the identity does not have a valid recovery key
+	auditRuleNoRecoveryKey = "no-recovery-key"
+	// auditRuleRevokedDeviceAdvertised a device is advertised, but all of its keys are revoked
+	auditRuleRevokedDeviceAdvertised = "revoked-device-advertised"
+	// auditRuleKeyNeverActivated a device key has not been used by an advertised device
+	auditRuleKeyNeverActivated = "key-never-activated"
+	// auditRuleRevokedBeforeValid a device key was revoked before it became valid
+	auditRuleRevokedBeforeValid = "revoked-before-valid"
+	// auditRuleRecoverySigned an operation was signed by a recovery key
+	auditRuleRecoverySigned = "recovery-signed"
+	// auditRuleRetroactiveRevocation a key was revoked from a time before the revocation was signed
+	auditRuleRetroactiveRevocation = "retroactive-revocation"
+)
+
+var (
+	maxKeyAge   int
+	failOn      string
+	skipRules   []string
+	auditFormat string
+
+	severities = map[string]int{
+		severityInfo:     1,
+		severityWarning:  2,
+		severityCritical: 3,
+	}
+
+	auditRules = []string{
+		auditRuleKeyAge,
+		auditRuleNoRecoveryKey,
+		auditRuleRevokedDeviceAdvertised,
+		auditRuleKeyNeverActivated,
+		auditRuleRevokedBeforeValid,
+		auditRuleRecoverySigned,
+		auditRuleRetroactiveRevocation,
+	}
+)
+
+// auditFinding a violation of the audit policy
+type auditFinding struct {
+	SelfID   string `json:"self_id"`
+	Severity string `json:"severity"`
+	Rule     string `json:"rule"`
+	KID      string `json:"kid,omitempty"`
+	DID      string `json:"did,omitempty"`
+	Sequence int    `json:"sequence,omitempty"`
+	Message  string `json:"message"`
+}
+
+var identityAuditCommand = &cobra.Command{
+	Use:   "audit",
+	Short: "audits an identities keys against a policy",
+	Long:  "analyses an identities signature graph and devices for key hygiene issues, such as old device keys, a missing recovery key or revoked devices that are still advertised. Exits with a non-zero status if any findings are at or above the '--fail-on' severity",
+	Run: func(cmd *cobra.Command, args []string) {
+		checkAuditPolicy()
+
+		if inventoryFile != "" {
+			auditInventory(cmd.Context(), mustLoadInventory())
+			return
+		}
+
+		if len(args) < 1 {
+			check(errors.New("you must specify an app identity [appID]"))
+		}
+
+		sk := mustSecretKey(secretKey, keyTypeSecret, "secret key")
+
+		if appID == "" {
+			appID = args[0]
+		}
+
+		client := rest(cmd.Context(), appID, sk)
+
+		done := make(chan error)
+
+		go log("auditing identity", done)
+
+		findings, err := auditIdentity(client, args[0], time.Now())
+		done <- err
+
+		if err != nil {
+			exit(1)
+		}
+
+		printFindings(findings)
+
+		if violates(findings) {
+			exit(1)
+		}
+	},
+}
+
+func init() {
+	identityCommand.AddCommand(identityAuditCommand)
+	identityAuditCommand.Flags().StringVarP(&secretKey, "secret-key", "s", "", "Device secret key")
+	identityAuditCommand.Flags().StringVarP(&appID, "app-id", "a", "", "App identity to authenticate as, defaults to the identity being audited")
+	identityAuditCommand.Flags().IntVarP(&maxKeyAge, "max-key-age", "m", 90, "Maximum age of a device key in days")
+	identityAuditCommand.Flags().StringVarP(&failOn, "fail-on", "F", severityWarning, "Minimum severity of a finding that fails the audit [critical, warning, info]")
+	identityAuditCommand.Flags().StringSliceVarP(&skipRules, "skip", "x", nil, "Rules to skip ["+strings.Join(auditRules, ", ")+"]")
+	identityAuditCommand.Flags().StringVarP(&auditFormat, "format", "f", auditFormatTable, "Format of the report [table, json]")
+	identityAuditCommand.Flags().StringVarP(&inventoryFile, "identities", "I", "", "Inventory file of identities to audit")
+	identityAuditCommand.Flags().IntVarP(&concurrency, "concurrency", "c", 4, "Number of identities to audit concurrently")
+}
+
+// auditInventory audits every identity in an inventory, exiting with a
+// non-zero status if any identity violates the policy or fails to be audited
+func auditInventory(ctx context.Context, inv *inventory) {
+	done := make(chan error)
+
+	go log(fmt.Sprintf("auditing %d identities", len(inv.Identities)), done)
+
+	now := time.Now()
+
+	results := forEachIdentity(ctx, inv, func(i inventoryIdentity, sk *key) (interface{}, error) {
+		client, err := newRest(ctx, i.SelfID, sk)
+		if err != nil {
+			return nil, err
+		}
+
+		return auditIdentity(client, i.SelfID, now)
+	})
+
+	done <- nil
+
+	var findings []auditFinding
+
+	for _, r := range results {
+		if r.Err == nil {
+			findings = append(findings, r.Value.([]auditFinding)...)
+		}
+	}
+
+	sort.SliceStable(findings, func(i, j int) bool {
+		return severities[findings[i].Severity] > severities[findings[j].Severity]
+	})
+
+	printFindings(findings)
+
+	failed := reportErrors(results)
+
+	if failed || violates(findings) {
+		exit(1)
+	}
+}
+
+// checkAuditPolicy validates the audit policy flags
+func checkAuditPolicy() {
+	if _, ok := severities[failOn]; !ok {
+		check(errors.New("fail on severity must be one of [critical, warning, info]"))
+	}
+
+	if auditFormat != auditFormatTable && auditFormat != auditFormatJSON {
+		check(errors.New("report format must be one of [table, json]"))
+	}
+
+	for _, r := range skipRules {
+		if !contains(auditRules, r) {
+			check(fmt.Errorf("unknown rule '%s', must be one of [%s]", r, strings.Join(auditRules, ", ")))
+		}
+	}
+}
+
+// auditIdentity fetches an identities history and devices, and audits them against the policy
+func auditIdentity(client *transport.Rest, selfID string, now time.Time) ([]auditFinding, error) {
+	identity, sg, err := fetchIdentity(client, selfID)
+	if err != nil {
+		return nil, err
+	}
+
+	keys, err := keyHistory(identity.History, sg)
+	if err != nil {
+		return nil, err
+	}
+
+	devices, err := fetchDevices(client, selfID)
+	if err != nil {
+		return nil, err
+	}
+
+	return audit(selfID, identity.History, keys, devices, now)
+}
+
+// audit checks an identities keys and devices against the policy, returning
+// findings ordered by severity
+func audit(selfID string, history []json.RawMessage, keys []*keyInfo, devices []string, now time.Time) ([]auditFinding, error) {
+	var findings []auditFinding
+
+	add := func(f auditFinding) {
+		if contains(skipRules, f.Rule) {
+			return
+		}
+
+		f.SelfID = selfID
+		findings = append(findings, f)
+	}
+
+	var recovery bool
+
+	for _, k := range keys {
+		state := k.stateAt(now)
+
+		if k.Type == siggraph.TypeRecoveryKey {
+			if state == keyStateValid {
+				recovery = true
+			}
+			continue
+		}
+
+		age := int(now.Sub(time.Unix(k.CreatedAt, 0)).Hours() / 24)
+
+		if state == keyStateValid && age > maxKeyAge {
+			add(auditFinding{
+				Severity: severityWarning,
+				Rule:     auditRuleKeyAge,
+				KID:      k.KID,
+				DID:      k.DID,
+				Sequence: k.Sequence,
+				Message:  fmt.Sprintf("device key is %d days old, exceeding the maximum age of %d days", age, maxKeyAge),
+			})
+		}
+
+		if state == keyStateValid && !contains(devices, k.DID) {
+			add(auditFinding{
+				Severity: severityWarning,
+				Rule:     auditRuleKeyNeverActivated,
+				KID:      k.KID,
+				DID:      k.DID,
+				Sequence: k.Sequence,
+				Message:  "device key is valid, but its device is not advertised as active",
+			})
+		}
+
+		if k.RevokedAt != 0 && !k.validAt(time.Unix(k.RevokedAt-1, 0)) {
+			add(auditFinding{
+				Severity: severityInfo,
+				Rule:     auditRuleRevokedBeforeValid,
+				KID:      k.KID,
+				DID:      k.DID,
+				Sequence: k.Sequence,
+				Message:  "device key was revoked before it became valid",
+			})
+		}
+	}
+
+	if !recovery {
+		add(auditFinding{
+			Severity: severityCritical,
+			Rule:     auditRuleNoRecoveryKey,
+			Message:  "identity does not have a valid recovery key",
+		})
+	}
+
+	for _, did := range devices {
+		var revoked, valid int
+
+		for _, k := range keys {
+			if k.DID != did || k.Type != siggraph.TypeDeviceKey {
+				continue
+			}
+
+			if k.stateAt(now) == keyStateRevoked {
+				revoked++
+			} else {
+				valid++
+			}
+		}
+
+		if revoked > 0 && valid == 0 {
+			add(auditFinding{
+				Severity: severityCritical,
+				Rule:     auditRuleRevokedDeviceAdvertised,
+				DID:      did,
+				Message:  "device is advertised as active, but all of its keys have been revoked",
+			})
+		}
+	}
+
+	for _, operation := range history {
+		op, err := siggraph.ParseOperation(operation)
+		if err != nil {
+			return nil, err
+		}
+
+		signer := findKey(keys, op.SignatureKeyID())
+		if signer != nil && signer.Type == siggraph.TypeRecoveryKey {
+			add(auditFinding{
+				Severity: severityWarning,
+				Rule:     auditRuleRecoverySigned,
+				KID:      signer.KID,
+				Sequence: op.Sequence,
+				Message:  "operation was signed by a recovery key",
+			})
+		}
+
+		signedAt := seconds(op.Timestamp)
+
+		for _, a := range op.Actions {
+			if a.Action != siggraph.ActionKeyRevoke || a.EffectiveFrom == 0 || seconds(a.EffectiveFrom) >= signedAt {
+				continue
+			}
+
+			add(auditFinding{
+				Severity: severityWarning,
+				Rule:     auditRuleRetroactiveRevocation,
+				KID:      a.KID,
+				Sequence: op.Sequence,
+				Message:  fmt.Sprintf("key was revoked from %s, %s before the revocation was signed", formatTime(seconds(a.EffectiveFrom)), time.Duration(signedAt-seconds(a.EffectiveFrom))*time.Second),
+			})
+		}
+	}
+
+	sort.SliceStable(findings, func(i, j int) bool {
+		return severities[findings[i].Severity] > severities[findings[j].Severity]
+	})
+
+	return findings, nil
+}
+
+// printFindings outputs findings in the format specified by the '--format' flag
+func printFindings(findings []auditFinding) {
+	if auditFormat == auditFormatJSON {
+		if findings == nil {
+			findings = []auditFinding{}
+		}
+
+		data, err := json.MarshalIndent(findings, "", "  ")
+		check(err)
+
+		fmt.Println(string(data))
+
+		return
+	}
+
+	progressf("\n")
+
+	if len(findings) < 1 {
+		fmt.Println("no findings")
+		return
+	}
+
+	counts := make(map[string]int)
+
+	var lines [][]string
+
+	for _, f := range findings {
+		counts[f.Severity]++
+
+		var sequence string
+		if f.KID != "" {
+			sequence = strconv.Itoa(f.Sequence)
+		}
+
+		lines = append(lines, []string{f.SelfID, colorSeverity(f.Severity), f.Rule, f.KID, f.DID, sequence, f.Message})
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"SELF ID", "SEVERITY", "RULE", "KID", "DID", "SEQUENCE", "MESSAGE"})
+	table.SetAlignment(tablewriter.ALIGN_LEFT)
+	table.SetAutoWrapText(false)
+	table.SetHeaderLine(false)
+	table.SetRowLine(false)
+	table.SetBorder(false)
+	table.SetCenterSeparator("")
+	table.SetColumnSeparator("")
+	table.AppendBulk(lines)
+	table.Render()
+
+	fmt.Printf("\n%d findings (%d critical, %d warning, %d info)\n", len(findings), counts[severityCritical], counts[severityWarning], counts[severityInfo])
+}
+
+// violates returns true if any finding is at or above the '--fail-on' severity
+func violates(findings []auditFinding) bool {
+	for _, f := range findings {
+		if severities[f.Severity] >= severities[failOn] {
+			return true
+		}
+	}
+
+	return false
+}
+
+func colorSeverity(severity string) string {
+	switch severity {
+	case severityCritical:
+		return paint(os.Stdout, colorRed, severity)
+	case severityWarning:
+		return paint(os.Stdout, colorYellow, severity)
+	}
+
+	return paint(os.Stdout, colorBlue, severity)
+}
+
+// contains returns true if the value is in the list
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
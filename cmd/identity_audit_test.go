@@ -0,0 +1,257 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package cmd
+
+import (
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/joinself/self-go-sdk/pkg/siggraph"
+)
+
+// setAuditPolicy sets the audit policy for the duration of a test
+func setAuditPolicy(t *testing.T, maxAge int, fail string, skip ...string) {
+	t.Helper()
+
+	previousAge, previousFail, previousSkip := maxKeyAge, failOn, skipRules
+
+	t.Cleanup(func() {
+		maxKeyAge, failOn, skipRules = previousAge, previousFail, previousSkip
+	})
+
+	maxKeyAge, failOn, skipRules = maxAge, fail, skip
+}
+
+// findingRules returns the sorted rules of a set of findings
+func findingRules(findings []auditFinding) string {
+	var rules []string
+
+	for _, f := range findings {
+		rules = append(rules, f.Rule+":"+f.KID+f.DID)
+	}
+
+	sort.Strings(rules)
+
+	return strings.Join(rules, ",")
+}
+
+func TestAuditIdentity(t *testing.T) {
+	now := time.Now()
+
+	revoke := func(kid, did string, effectiveFrom time.Time) siggraph.Action {
+		return siggraph.Action{
+			KID:           kid,
+			DID:           did,
+			Type:          siggraph.TypeDeviceKey,
+			Action:        siggraph.ActionKeyRevoke,
+			EffectiveFrom: effectiveFrom.Unix(),
+		}
+	}
+
+	addKey := func(k *key, did string, effectiveFrom time.Time) siggraph.Action {
+		return siggraph.Action{
+			KID:           k.kid,
+			DID:           did,
+			Type:          siggraph.TypeDeviceKey,
+			Action:        siggraph.ActionKeyAdd,
+			EffectiveFrom: effectiveFrom.Unix(),
+			Key:           enc.EncodeToString(k.publicKey()),
+		}
+	}
+
+	tests := []struct {
+		name       string
+		created    time.Time
+		advertised []string
+		setup      func(ti *testIdentity)
+		skip       []string
+		expected   string
+	}{
+		{
+			name:       "no findings",
+			created:    now.Add(-time.Hour),
+			advertised: []string{"1", "2"},
+			expected:   "",
+		},
+		{
+			name:       "key age",
+			created:    now.Add(-100 * 24 * time.Hour),
+			advertised: []string{"1", "2"},
+			expected:   "key-age:11,key-age:22",
+		},
+		{
+			name:       "key age skipped",
+			created:    now.Add(-100 * 24 * time.Hour),
+			advertised: []string{"1", "2"},
+			skip:       []string{auditRuleKeyAge},
+			expected:   "",
+		},
+		{
+			name:       "key never activated",
+			created:    now.Add(-time.Hour),
+			advertised: []string{"1"},
+			expected:   "key-never-activated:22",
+		},
+		{
+			name:       "revoked device advertised",
+			created:    now.Add(-time.Hour),
+			advertised: []string{"1", "2"},
+			setup: func(ti *testIdentity) {
+				ti.add(t, ti.keys["1"], now.Add(-30*time.Minute), revoke("2", "2", now.Add(-30*time.Minute)))
+			},
+			expected: "revoked-device-advertised:2",
+		},
+		{
+			name:       "retroactive revocation",
+			created:    now.Add(-time.Hour),
+			advertised: []string{"1"},
+			setup: func(ti *testIdentity) {
+				ti.add(t, ti.keys["1"], now.Add(-10*time.Minute), revoke("2", "2", now.Add(-30*time.Minute)))
+			},
+			expected: "retroactive-revocation:2",
+		},
+		{
+			name:       "revoked before valid",
+			created:    now.Add(-time.Hour),
+			advertised: []string{"1", "2"},
+			setup: func(ti *testIdentity) {
+				nk := generateKey(keyTypeSecret, "4")
+				ti.add(t, ti.keys["1"], now.Add(-30*time.Minute), addKey(nk, "3", now.Add(-10*time.Minute)))
+				ti.add(t, ti.keys["1"], now.Add(-20*time.Minute), revoke("4", "3", now.Add(-20*time.Minute)))
+			},
+			expected: "revoked-before-valid:43",
+		},
+		{
+			name:       "recovery signed",
+			created:    now.Add(-time.Hour),
+			advertised: []string{"1"},
+			setup: func(ti *testIdentity) {
+				nk := generateKey(keyTypeSecret, "4")
+				rk := generateKey(keyTypeRecovery, "5")
+
+				// recovering the identity revokes all of its existing keys
+				ti.add(t, ti.keys["3"], now.Add(-30*time.Minute),
+					siggraph.Action{
+						KID:           "3",
+						Type:          siggraph.TypeRecoveryKey,
+						Action:        siggraph.ActionKeyRevoke,
+						EffectiveFrom: now.Add(-30 * time.Minute).Unix(),
+					},
+					addKey(nk, "1", now.Add(-30*time.Minute)),
+					siggraph.Action{
+						KID:           rk.kid,
+						Type:          siggraph.TypeRecoveryKey,
+						Action:        siggraph.ActionKeyAdd,
+						EffectiveFrom: now.Add(-30 * time.Minute).Unix(),
+						Key:           enc.EncodeToString(rk.publicKey()),
+					},
+				)
+			},
+			expected: "recovery-signed:3",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			setAuditPolicy(t, 90, severityWarning, tc.skip...)
+
+			ti := newTestIdentity(t, "app", tc.created, "1", "2")
+			ti.devices = tc.advertised
+
+			if tc.setup != nil {
+				tc.setup(ti)
+			}
+
+			findings, err := auditIdentity(testRest(t, ti), "app", now)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if findingRules(findings) != tc.expected {
+				t.Fatalf("expected findings '%s', got '%s'", tc.expected, findingRules(findings))
+			}
+
+			for _, f := range findings {
+				if f.SelfID != "app" {
+					t.Fatalf("expected finding to be for identity app, got %s", f.SelfID)
+				}
+			}
+		})
+	}
+}
+
+func TestAuditNoRecoveryKey(t *testing.T) {
+	setAuditPolicy(t, 90, severityCritical)
+
+	now := time.Now()
+
+	ti := newTestIdentity(t, "app", now.Add(-time.Hour), "1")
+
+	keys, err := keyHistory(ti.history, ti.graph(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var deviceKeys []*keyInfo
+
+	for _, k := range keys {
+		if k.Type == siggraph.TypeDeviceKey {
+			deviceKeys = append(deviceKeys, k)
+		}
+	}
+
+	findings, err := audit("app", ti.history, deviceKeys, []string{"1"}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if findingRules(findings) != auditRuleNoRecoveryKey+":" {
+		t.Fatalf("expected a missing recovery key finding, got '%s'", findingRules(findings))
+	}
+
+	if !violates(findings) {
+		t.Fatal("expected a critical finding to violate the policy")
+	}
+}
+
+func TestAuditFindingsOrder(t *testing.T) {
+	setAuditPolicy(t, 90, severityCritical)
+
+	now := time.Now()
+
+	ti := newTestIdentity(t, "app", now.Add(-100*24*time.Hour), "1", "2")
+
+	keys, err := keyHistory(ti.history, ti.graph(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the key age warnings are not severe enough to fail the audit
+	findings, err := audit("app", ti.history, keys, []string{"1", "2"}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(findings) != 2 || violates(findings) {
+		t.Fatalf("expected warnings that do not violate the policy, got %+v", findings)
+	}
+
+	var deviceKeys []*keyInfo
+
+	for _, k := range keys {
+		if k.Type == siggraph.TypeDeviceKey {
+			deviceKeys = append(deviceKeys, k)
+		}
+	}
+
+	findings, err = audit("app", ti.history, deviceKeys, []string{"1", "2"}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(findings) != 3 || findings[0].Severity != severityCritical {
+		t.Fatalf("expected findings to be ordered by severity, got %+v", findings)
+	}
+}
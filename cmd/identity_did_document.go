@@ -0,0 +1,336 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joinself/self-go-sdk/pkg/siggraph"
+	"github.com/joinself/self-go-sdk/pkg/transport"
+	"github.com/spf13/cobra"
+)
+
+const (
+	didPrefix = "did:self:"
+
+	verificationKeyEd25519 = "Ed25519VerificationKey2020"
+	verificationKeyJWK     = "JsonWebKey2020"
+)
+
+var (
+	didKeyType string
+
+	// errNotFound the api responded that the resource requested does not exist
+	errNotFound = errors.New("not found")
+
+	// selfIDPattern the characters a self id can contain
+	selfIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+)
+
+// didDocument a w3c did document
+type didDocument struct {
+	Context              []string             `json:"@context"`
+	ID                   string               `json:"id"`
+	VerificationMethod   []verificationMethod `json:"verificationMethod"`
+	Authentication       []string             `json:"authentication"`
+	AssertionMethod      []string             `json:"assertionMethod"`
+	CapabilityInvocation []string             `json:"capabilityInvocation"`
+}
+
+// notFoundTransport returns errNotFound for requests the api responds to with a 404,
+// as the sdk's rest client does not expose the status of a failed request
+type notFoundTransport struct {
+	next http.RoundTripper
+}
+
+// verificationMethod a did document verification method
+type verificationMethod struct {
+	ID                 string                 `json:"id"`
+	Type               string                 `json:"type"`
+	Controller         string                 `json:"controller"`
+	PublicKeyMultibase string                 `json:"publicKeyMultibase,omitempty"`
+	PublicKeyJWK       map[string]interface{} `json:"publicKeyJwk,omitempty"`
+	Created            string                 `json:"created,omitempty"`
+	Revoked            string                 `json:"revoked,omitempty"`
+}
+
+// didResolution a did resolution result, as returned by a did resolver
+type didResolution struct {
+	Context               string                 `json:"@context"`
+	DIDDocument           *didDocument           `json:"didDocument"`
+	DIDResolutionMetadata map[string]interface{} `json:"didResolutionMetadata"`
+	DIDDocumentMetadata   map[string]interface{} `json:"didDocumentMetadata"`
+}
+
+var identityDIDDocumentCommand = &cobra.Command{
+	Use:   "did-document",
+	Short: "outputs an identity as a w3c did document",
+	Long:  "outputs an identities signature graph as a w3c did document. If an address to listen on is provided, a did resolver compatible http endpoint will be served instead",
+	Run: func(cmd *cobra.Command, args []string) {
+		if didKeyType != verificationKeyEd25519 && didKeyType != verificationKeyJWK {
+			check(fmt.Errorf("key type must be one of [%s, %s]", verificationKeyEd25519, verificationKeyJWK))
+		}
+
+		sk := mustSecretKey(secretKey, keyTypeSecret, "secret key")
+
+		if listenAddr != "" {
+			if appID == "" {
+				check(errors.New("you must specify the app identity to authenticate as [--app-id]"))
+			}
+
+			serveDIDResolver(cmd.Context(), appID, sk)
+			return
+		}
+
+		if len(args) < 1 {
+			check(errors.New("you must specify an identity [selfID]"))
+		}
+
+		if appID == "" {
+			appID = args[0]
+		}
+
+		client := rest(cmd.Context(), appID, sk)
+
+		identity, sg := getIdentity(client, args[0])
+
+		keys, err := keyHistory(identity.History, sg)
+		check(err)
+
+		doc, err := newDIDDocument(args[0], keys)
+		check(err)
+
+		data, err := json.MarshalIndent(doc, "", "  ")
+		check(err)
+
+		fmt.Println(string(data))
+	},
+}
+
+func init() {
+	identityCommand.AddCommand(identityDIDDocumentCommand)
+	identityDIDDocumentCommand.Flags().StringVarP(&secretKey, "secret-key", "s", "", "Device secret key")
+	identityDIDDocumentCommand.Flags().StringVarP(&appID, "app-id", "a", "", "App identity to authenticate as, defaults to the identity being resolved")
+	identityDIDDocumentCommand.Flags().StringVarP(&didKeyType, "key-type", "t", verificationKeyEd25519, "Verification method type ["+verificationKeyEd25519+", "+verificationKeyJWK+"]")
+	identityDIDDocumentCommand.Flags().StringVarP(&listenAddr, "listen", "l", "", "Address to serve a did resolver endpoint on, i.e. ':8080'")
+}
+
+// newDIDDocument builds a did document from the keys on an identities signature graph.
+// Revoked keys are listed as verification methods, but are not referenced by any
+// verification relationship. Keys with a revocation that has not taken effect yet
+// are still valid
+func newDIDDocument(selfID string, keys []*keyInfo) (*didDocument, error) {
+	did := didPrefix + selfID
+	now := time.Now()
+
+	doc := didDocument{
+		Context:              []string{"https://www.w3.org/ns/did/v1"},
+		ID:                   did,
+		VerificationMethod:   []verificationMethod{},
+		Authentication:       []string{},
+		AssertionMethod:      []string{},
+		CapabilityInvocation: []string{},
+	}
+
+	switch didKeyType {
+	case verificationKeyJWK:
+		doc.Context = append(doc.Context, "https://w3id.org/security/suites/jws-2020/v1")
+	default:
+		doc.Context = append(doc.Context, "https://w3id.org/security/suites/ed25519-2020/v1")
+	}
+
+	for _, k := range keys {
+		vm := verificationMethod{
+			ID:         did + "#" + k.KID,
+			Type:       didKeyType,
+			Controller: did,
+			Created:    time.Unix(k.CreatedAt, 0).UTC().Format(time.RFC3339),
+		}
+
+		switch didKeyType {
+		case verificationKeyJWK:
+			jk, err := jwkInfo(k)
+			if err != nil {
+				return nil, err
+			}
+
+			vm.PublicKeyJWK = jk
+		default:
+			// multicodec prefix for an ed25519 public key
+			vm.PublicKeyMultibase = "z" + base58(append([]byte{0xed, 0x01}, k.PublicKey...))
+		}
+
+		revoked := k.stateAt(now) == keyStateRevoked
+
+		if revoked {
+			vm.Revoked = time.Unix(k.RevokedAt, 0).UTC().Format(time.RFC3339)
+		}
+
+		doc.VerificationMethod = append(doc.VerificationMethod, vm)
+
+		if revoked {
+			continue
+		}
+
+		if k.Type == siggraph.TypeRecoveryKey {
+			doc.CapabilityInvocation = append(doc.CapabilityInvocation, vm.ID)
+			continue
+		}
+
+		doc.Authentication = append(doc.Authentication, vm.ID)
+		doc.AssertionMethod = append(doc.AssertionMethod, vm.ID)
+		doc.CapabilityInvocation = append(doc.CapabilityInvocation, vm.ID)
+	}
+
+	return &doc, nil
+}
+
+// serveDIDResolver serves did resolution results for self identities, in the
+// format used by the universal resolver at '/1.0/identifiers/{did}'
+func serveDIDResolver(ctx context.Context, appID string, sk *key) {
+	client, err := newRestWithTransport(appID, sk, &notFoundTransport{
+		next: &contextTransport{ctx: ctx, next: apiClient().Transport},
+	})
+
+	check(err)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/1.0/identifiers/", resolveDID(client))
+
+	progressf("serving did resolver on %s\n", listenAddr)
+
+	check(serve(ctx, listenAddr, mux))
+}
+
+// resolveDID returns a handler that resolves a did to a did document. Identities
+// that do not exist are not found, while any other failure to get an identity
+// from the api is reported as a bad gateway. The details of any failure are
+// logged, rather than returned to the client
+func resolveDID(client *transport.Rest) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		did := strings.TrimPrefix(r.URL.Path, "/1.0/identifiers/")
+		selfID := strings.TrimPrefix(did, didPrefix)
+
+		// the self id is used in the path of api requests, so must not contain any
+		// characters that would change which of the api's endpoints is requested
+		if !strings.HasPrefix(did, didPrefix) || !selfIDPattern.MatchString(selfID) {
+			writeDIDResolution(w, http.StatusBadRequest, &didResolution{
+				DIDResolutionMetadata: map[string]interface{}{"error": "invalidDid"},
+			})
+			return
+		}
+
+		identity, sg, err := fetchIdentity(client, selfID)
+		if errors.Is(err, errNotFound) {
+			writeDIDResolution(w, http.StatusNotFound, &didResolution{
+				DIDResolutionMetadata: map[string]interface{}{"error": "notFound", "message": "identity " + selfID + " does not exist"},
+			})
+			return
+		}
+
+		if err != nil {
+			errorf("failed to get identity %s: %s\n", selfID, err.Error())
+			writeDIDResolution(w, http.StatusBadGateway, &didResolution{
+				DIDResolutionMetadata: map[string]interface{}{"error": "internalError", "message": "failed to get the identity from the api"},
+			})
+			return
+		}
+
+		keys, err := keyHistory(identity.History, sg)
+
+		var doc *didDocument
+
+		if err == nil {
+			doc, err = newDIDDocument(selfID, keys)
+		}
+
+		if err != nil {
+			errorf("failed to build did document for %s: %s\n", selfID, err.Error())
+			writeDIDResolution(w, http.StatusInternalServerError, &didResolution{
+				DIDResolutionMetadata: map[string]interface{}{"error": "internalError", "message": "failed to build the did document"},
+			})
+			return
+		}
+
+		metadata := map[string]interface{}{
+			"versionId": strconv.Itoa(sg.NextSequence() - 1),
+		}
+
+		if len(keys) > 0 {
+			metadata["created"] = time.Unix(keys[0].CreatedAt, 0).UTC().Format(time.RFC3339)
+		}
+
+		writeDIDResolution(w, http.StatusOK, &didResolution{
+			DIDDocument:           doc,
+			DIDResolutionMetadata: map[string]interface{}{"contentType": "application/did+ld+json"},
+			DIDDocumentMetadata:   metadata,
+		})
+	}
+}
+
+// RoundTrip sends a request, returning errNotFound if the api responds with a 404
+func (t *notFoundTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, errNotFound
+	}
+
+	return resp, nil
+}
+
+func writeDIDResolution(w http.ResponseWriter, status int, resolution *didResolution) {
+	resolution.Context = "https://w3id.org/did-resolution/v1"
+
+	if resolution.DIDDocumentMetadata == nil {
+		resolution.DIDDocumentMetadata = map[string]interface{}{}
+	}
+
+	w.Header().Set("Content-Type", "application/ld+json;profile=\"https://w3id.org/did-resolution\"")
+	w.WriteHeader(status)
+
+	json.NewEncoder(w).Encode(resolution)
+}
+
+// base58 encodes data using the bitcoin base58 alphabet
+func base58(data []byte) string {
+	const alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+	var out []byte
+
+	n := new(big.Int).SetBytes(data)
+	radix := big.NewInt(58)
+	mod := new(big.Int)
+
+	for n.Sign() > 0 {
+		n.DivMod(n, radix, mod)
+		out = append(out, alphabet[mod.Int64()])
+	}
+
+	// leading zero bytes are encoded as the first character of the alphabet
+	for _, b := range data {
+		if b != 0 {
+			break
+		}
+		out = append(out, alphabet[0])
+	}
+
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+
+	return string(out)
+}
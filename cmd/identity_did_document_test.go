@@ -0,0 +1,156 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package cmd
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/joinself/self-go-sdk/pkg/siggraph"
+)
+
+func TestResolveDID(t *testing.T) {
+	ti := newTestIdentity(t, "app", time.Now().Add(-time.Hour), "1")
+
+	testAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/identities/unavailable":
+			http.Error(w, "database unavailable", http.StatusInternalServerError)
+		default:
+			ti.ServeHTTP(w, r)
+		}
+	}))
+
+	client, err := newRestWithTransport("app", ti.keys["1"], &notFoundTransport{next: apiClient().Transport})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resolver := resolveDID(client)
+
+	tests := []struct {
+		name   string
+		did    string
+		status int
+		err    string
+	}{
+		{"resolved", "did:self:app", http.StatusOK, ""},
+		{"invalid did", "did:web:app", http.StatusBadRequest, "invalidDid"},
+		{"not found", "did:self:unknown", http.StatusNotFound, "notFound"},
+		{"api error", "did:self:unavailable", http.StatusBadGateway, "internalError"},
+		{"empty self id", "did:self:", http.StatusBadRequest, "invalidDid"},
+		{"encoded path", "did:self:app%2Fdevices", http.StatusBadRequest, "invalidDid"},
+		{"encoded query", "did:self:app%3Ftoken=1", http.StatusBadRequest, "invalidDid"},
+		{"parent path", "did:self:..", http.StatusBadRequest, "invalidDid"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+
+			resolver(w, httptest.NewRequest(http.MethodGet, "/1.0/identifiers/"+tc.did, nil))
+
+			if w.Code != tc.status {
+				t.Fatalf("expected status %d, got %d: %s", tc.status, w.Code, w.Body.String())
+			}
+
+			var resolution struct {
+				DIDDocument           *didDocument           `json:"didDocument"`
+				DIDResolutionMetadata map[string]interface{} `json:"didResolutionMetadata"`
+			}
+
+			err := json.NewDecoder(w.Body).Decode(&resolution)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tc.err != "" {
+				if resolution.DIDResolutionMetadata["error"] != tc.err {
+					t.Fatalf("expected error %s, got %v", tc.err, resolution.DIDResolutionMetadata)
+				}
+
+				// the api's response is not passed on to the client
+				message, _ := resolution.DIDResolutionMetadata["message"].(string)
+				if strings.Contains(message, "database") {
+					t.Fatalf("expected a generic error message, got '%s'", message)
+				}
+
+				return
+			}
+
+			if resolution.DIDDocument == nil || resolution.DIDDocument.ID != tc.did || len(resolution.DIDDocument.VerificationMethod) != 2 {
+				t.Fatalf("unexpected did document %+v", resolution.DIDDocument)
+			}
+		})
+	}
+}
+
+func TestNewDIDDocument(t *testing.T) {
+	now := time.Now()
+
+	ti := newTestIdentity(t, "app", now.Add(-time.Hour), "1", "2", "3")
+
+	// device 2's key has been revoked, device 3's key is scheduled to be revoked
+	ti.add(t, ti.keys["1"], now.Add(-30*time.Minute),
+		siggraph.Action{
+			KID:           "2",
+			DID:           "2",
+			Type:          siggraph.TypeDeviceKey,
+			Action:        siggraph.ActionKeyRevoke,
+			EffectiveFrom: now.Add(-30 * time.Minute).Unix(),
+		},
+		siggraph.Action{
+			KID:           "3",
+			DID:           "3",
+			Type:          siggraph.TypeDeviceKey,
+			Action:        siggraph.ActionKeyRevoke,
+			EffectiveFrom: now.Add(time.Hour).Unix(),
+		},
+	)
+
+	keys, err := keyHistory(ti.history, ti.graph(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	doc, err := newDIDDocument("app", keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(doc.VerificationMethod) != 4 {
+		t.Fatalf("expected every key to be listed as a verification method, got %d", len(doc.VerificationMethod))
+	}
+
+	var revoked []string
+
+	for _, vm := range doc.VerificationMethod {
+		if vm.Revoked != "" {
+			revoked = append(revoked, vm.ID)
+		}
+	}
+
+	expected := map[string]string{
+		"revoked":              "did:self:app#2",
+		"authentication":       "did:self:app#1,did:self:app#3",
+		"assertionMethod":      "did:self:app#1,did:self:app#3",
+		"capabilityInvocation": "did:self:app#1,did:self:app#3,did:self:app#4",
+	}
+
+	actual := map[string]string{
+		"revoked":              strings.Join(revoked, ","),
+		"authentication":       strings.Join(doc.Authentication, ","),
+		"assertionMethod":      strings.Join(doc.AssertionMethod, ","),
+		"capabilityInvocation": strings.Join(doc.CapabilityInvocation, ","),
+	}
+
+	for name, ids := range expected {
+		if actual[name] != ids {
+			t.Fatalf("expected %s to be %s, got %s", name, ids, actual[name])
+		}
+	}
+}
@@ -0,0 +1,209 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/joinself/self-go-sdk/pkg/siggraph"
+	"github.com/spf13/cobra"
+)
+
+const (
+	graphFormatDOT     = "dot"
+	graphFormatMermaid = "mermaid"
+	graphFormatSVG     = "svg"
+
+	// the node representing the creation of the identity
+	genesisNode = "genesis"
+)
+
+var graphFormat string
+
+var identityGraphCommand = &cobra.Command{
+	Use:   "graph",
+	Short: "renders an identities signature graph",
+	Long:  "renders an identities signature graph, with keys as nodes and the operations that added and revoked them as edges. Rendering as svg requires graphviz to be installed",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) < 1 {
+			check(errors.New("you must specify an app identity [appID]"))
+		}
+
+		if graphFormat != graphFormatDOT && graphFormat != graphFormatMermaid && graphFormat != graphFormatSVG {
+			check(errors.New("graph format must be one of [dot, mermaid, svg]"))
+		}
+
+		sk := mustSecretKey(secretKey, keyTypeSecret, "secret key")
+
+		client := rest(cmd.Context(), args[0], sk)
+
+		app, sg := getIdentity(client, args[0])
+
+		keys, err := keyHistory(app.History, sg)
+		check(err)
+
+		now := time.Now()
+
+		switch graphFormat {
+		case graphFormatDOT:
+			fmt.Print(graphDOT(args[0], keys, now))
+		case graphFormatMermaid:
+			fmt.Print(graphMermaid(args[0], keys, now))
+		case graphFormatSVG:
+			var out bytes.Buffer
+
+			dot := exec.Command("dot", "-Tsvg")
+			dot.Stdin = strings.NewReader(graphDOT(args[0], keys, now))
+			dot.Stdout = &out
+			dot.Stderr = os.Stderr
+
+			err = dot.Run()
+			if errors.Is(err, exec.ErrNotFound) {
+				check(errors.New("rendering as svg requires graphviz's 'dot' command to be installed"))
+			}
+			check(err)
+
+			os.Stdout.Write(out.Bytes())
+		}
+	},
+}
+
+func init() {
+	identityCommand.AddCommand(identityGraphCommand)
+	identityGraphCommand.Flags().StringVarP(&secretKey, "secret-key", "s", "", "Device secret key")
+	identityGraphCommand.Flags().StringVarP(&graphFormat, "format", "f", graphFormatDOT, "Format to render the graph as [dot, mermaid, svg]")
+}
+
+// graphDOT renders the signature graph in graphviz dot format, with
+// keys coloured by whether they had been revoked at the given time
+func graphDOT(selfID string, keys []*keyInfo, at time.Time) string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "digraph %q {\n", selfID)
+	b.WriteString("  rankdir=LR;\n")
+	b.WriteString("  node [shape=box, style=\"rounded,filled\", fontname=\"Helvetica\"];\n")
+	b.WriteString("  edge [fontname=\"Helvetica\", fontsize=10];\n")
+	fmt.Fprintf(&b, "  %q [label=%q, shape=ellipse, fillcolor=\"#e2e3e5\"];\n", genesisNode, "identity\n"+selfID)
+
+	for _, k := range keys {
+		color := "#d4edda"
+		if k.stateAt(at) == keyStateRevoked {
+			color = "#f8d7da"
+		}
+
+		fmt.Fprintf(&b, "  %q [label=%q, fillcolor=%q];\n", k.KID, graphNodeLabel(k, at, "\n"), color)
+	}
+
+	for _, k := range keys {
+		fmt.Fprintf(&b, "  %q -> %q [label=%q];\n", graphSigner(k), k.KID, graphAddLabel(k, "\n"))
+
+		if k.RevokedSequence != 0 {
+			fmt.Fprintf(&b, "  %q -> %q [label=%q, style=dashed, color=\"#dc3545\", fontcolor=\"#dc3545\"];\n", k.RevokedBy, k.KID, graphRevokeLabel(k, "\n"))
+		}
+	}
+
+	b.WriteString("}\n")
+
+	return b.String()
+}
+
+// graphMermaid renders the signature graph as a mermaid flowchart, with
+// keys classed by whether they had been revoked at the given time
+func graphMermaid(selfID string, keys []*keyInfo, at time.Time) string {
+	var b strings.Builder
+
+	b.WriteString("graph LR\n")
+	fmt.Fprintf(&b, "  %s((\"identity<br/>%s\"))\n", genesisNode, selfID)
+
+	var valid, revoked []string
+
+	for _, k := range keys {
+		fmt.Fprintf(&b, "  k%s[\"%s\"]\n", k.KID, graphNodeLabel(k, at, "<br/>"))
+
+		if k.stateAt(at) == keyStateRevoked {
+			revoked = append(revoked, "k"+k.KID)
+		} else {
+			valid = append(valid, "k"+k.KID)
+		}
+	}
+
+	for _, k := range keys {
+		signer := "k" + graphSigner(k)
+		if signer == "k"+genesisNode {
+			signer = genesisNode
+		}
+
+		fmt.Fprintf(&b, "  %s -->|\"%s\"| k%s\n", signer, graphAddLabel(k, "<br/>"), k.KID)
+
+		if k.RevokedSequence != 0 {
+			fmt.Fprintf(&b, "  k%s -.->|\"%s\"| k%s\n", k.RevokedBy, graphRevokeLabel(k, "<br/>"), k.KID)
+		}
+	}
+
+	b.WriteString("  classDef valid fill:#d4edda,stroke:#28a745;\n")
+	b.WriteString("  classDef revoked fill:#f8d7da,stroke:#dc3545;\n")
+
+	if len(valid) > 0 {
+		fmt.Fprintf(&b, "  class %s valid;\n", strings.Join(valid, ","))
+	}
+
+	if len(revoked) > 0 {
+		fmt.Fprintf(&b, "  class %s revoked;\n", strings.Join(revoked, ","))
+	}
+
+	return b.String()
+}
+
+// graphSigner returns the node that signed the operation that added a key.
+// Keys that sign the operation that creates the identity are added by the
+// genesis node
+func graphSigner(k *keyInfo) string {
+	if k.Sequence == 0 && k.SignedBy == k.KID {
+		return genesisNode
+	}
+
+	return k.SignedBy
+}
+
+func graphNodeLabel(k *keyInfo, at time.Time, sep string) string {
+	label := []string{"kid " + k.KID}
+
+	if k.Type == siggraph.TypeRecoveryKey {
+		label = append(label, "recovery key")
+	} else {
+		label = append(label, "device "+k.DID)
+	}
+
+	if k.stateAt(at) == keyStateRevoked {
+		label = append(label, "revoked")
+	}
+
+	return strings.Join(label, sep)
+}
+
+func graphAddLabel(k *keyInfo, sep string) string {
+	label := []string{
+		fmt.Sprintf("seq %d", k.Sequence),
+		"signed by " + k.SignedBy,
+	}
+
+	if k.EffectiveFrom != 0 {
+		label = append(label, "from "+formatTime(k.EffectiveFrom))
+	}
+
+	return strings.Join(label, sep)
+}
+
+func graphRevokeLabel(k *keyInfo, sep string) string {
+	return strings.Join([]string{
+		fmt.Sprintf("revoked seq %d", k.RevokedSequence),
+		"signed by " + k.RevokedBy,
+		"from " + formatTime(k.RevokedAt),
+	}, sep)
+}
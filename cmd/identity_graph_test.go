@@ -0,0 +1,77 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package cmd
+
+import (
+	"testing"
+	"time"
+
+	"github.com/joinself/self-go-sdk/pkg/siggraph"
+)
+
+// testGraphKeys a small history, with an active device key, a device key that
+// has been revoked, a device key with a scheduled revocation and a recovery key
+func testGraphKeys() ([]*keyInfo, time.Time) {
+	created := int64(1600000000)
+
+	keys := []*keyInfo{
+		{KID: "1", DID: "1", Type: siggraph.TypeDeviceKey, Sequence: 0, SignedBy: "1", CreatedAt: created, EffectiveFrom: created},
+		{KID: "2", DID: "2", Type: siggraph.TypeDeviceKey, Sequence: 0, SignedBy: "1", CreatedAt: created, EffectiveFrom: created, RevokedAt: created + 3600, RevokedSequence: 1, RevokedBy: "1"},
+		{KID: "3", Type: siggraph.TypeRecoveryKey, Sequence: 0, SignedBy: "1", CreatedAt: created, EffectiveFrom: created},
+		{KID: "4", DID: "3", Type: siggraph.TypeDeviceKey, Sequence: 1, SignedBy: "1", CreatedAt: created + 3600, EffectiveFrom: created + 3600, RevokedAt: created + 3*3600, RevokedSequence: 2, RevokedBy: "1"},
+	}
+
+	return keys, time.Unix(created+2*3600, 0)
+}
+
+func TestGraphDOT(t *testing.T) {
+	keys, at := testGraphKeys()
+
+	expected := `digraph "app" {
+  rankdir=LR;
+  node [shape=box, style="rounded,filled", fontname="Helvetica"];
+  edge [fontname="Helvetica", fontsize=10];
+  "genesis" [label="identity\napp", shape=ellipse, fillcolor="#e2e3e5"];
+  "1" [label="kid 1\ndevice 1", fillcolor="#d4edda"];
+  "2" [label="kid 2\ndevice 2\nrevoked", fillcolor="#f8d7da"];
+  "3" [label="kid 3\nrecovery key", fillcolor="#d4edda"];
+  "4" [label="kid 4\ndevice 3", fillcolor="#d4edda"];
+  "genesis" -> "1" [label="seq 0\nsigned by 1\nfrom 2020-09-13T12:26:40Z"];
+  "1" -> "2" [label="seq 0\nsigned by 1\nfrom 2020-09-13T12:26:40Z"];
+  "1" -> "2" [label="revoked seq 1\nsigned by 1\nfrom 2020-09-13T13:26:40Z", style=dashed, color="#dc3545", fontcolor="#dc3545"];
+  "1" -> "3" [label="seq 0\nsigned by 1\nfrom 2020-09-13T12:26:40Z"];
+  "1" -> "4" [label="seq 1\nsigned by 1\nfrom 2020-09-13T13:26:40Z"];
+  "1" -> "4" [label="revoked seq 2\nsigned by 1\nfrom 2020-09-13T15:26:40Z", style=dashed, color="#dc3545", fontcolor="#dc3545"];
+}
+`
+
+	if graphDOT("app", keys, at) != expected {
+		t.Fatalf("expected graph:\n%s\ngot:\n%s", expected, graphDOT("app", keys, at))
+	}
+}
+
+func TestGraphMermaid(t *testing.T) {
+	keys, at := testGraphKeys()
+
+	expected := `graph LR
+  genesis(("identity<br/>app"))
+  k1["kid 1<br/>device 1"]
+  k2["kid 2<br/>device 2<br/>revoked"]
+  k3["kid 3<br/>recovery key"]
+  k4["kid 4<br/>device 3"]
+  genesis -->|"seq 0<br/>signed by 1<br/>from 2020-09-13T12:26:40Z"| k1
+  k1 -->|"seq 0<br/>signed by 1<br/>from 2020-09-13T12:26:40Z"| k2
+  k1 -.->|"revoked seq 1<br/>signed by 1<br/>from 2020-09-13T13:26:40Z"| k2
+  k1 -->|"seq 0<br/>signed by 1<br/>from 2020-09-13T12:26:40Z"| k3
+  k1 -->|"seq 1<br/>signed by 1<br/>from 2020-09-13T13:26:40Z"| k4
+  k1 -.->|"revoked seq 2<br/>signed by 1<br/>from 2020-09-13T15:26:40Z"| k4
+  classDef valid fill:#d4edda,stroke:#28a745;
+  classDef revoked fill:#f8d7da,stroke:#dc3545;
+  class k1,k3,k4 valid;
+  class k2 revoked;
+`
+
+	if graphMermaid("app", keys, at) != expected {
+		t.Fatalf("expected graph:\n%s\ngot:\n%s", expected, graphMermaid("app", keys, at))
+	}
+}
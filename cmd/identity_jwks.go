@@ -0,0 +1,104 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+var identityJWKSCommand = &cobra.Command{
+	Use:   "jwks",
+	Short: "outputs an identities public keys as a json web key set",
+	Long:  "outputs all valid public keys from an identities signature graph as a json web key set. Revoked keys can optionally be included, annotated with the time they were revoked",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) < 1 {
+			check(errors.New("you must specify an app identity [appID]"))
+		}
+
+		sk := mustSecretKey(secretKey, keyTypeSecret, "secret key")
+
+		client := rest(cmd.Context(), args[0], sk)
+
+		app, sg := getIdentity(client, args[0])
+
+		history, sg, at, err := pointInTime(app.History, sg)
+		check(err)
+
+		keys, err := keyHistory(history, sg)
+		check(err)
+
+		jwks := struct {
+			Keys []map[string]interface{} `json:"keys"`
+		}{
+			Keys: []map[string]interface{}{},
+		}
+
+		for _, k := range keys {
+			switch k.stateAt(at) {
+			case keyStateValid:
+			case keyStateRevoked:
+				if !includeRevoked {
+					continue
+				}
+			default:
+				continue
+			}
+
+			jk, err := jwkInfo(k)
+			check(err)
+
+			// the key had not been revoked yet at the point in time
+			if timeTravel() && k.revokedAsOf(at) == 0 {
+				delete(jk, "self_revoked_at")
+			}
+
+			jwks.Keys = append(jwks.Keys, jk)
+		}
+
+		data, err := json.MarshalIndent(jwks, "", "  ")
+		check(err)
+
+		fmt.Println(string(data))
+	},
+}
+
+func init() {
+	identityCommand.AddCommand(identityJWKSCommand)
+	identityJWKSCommand.Flags().StringVarP(&secretKey, "secret-key", "s", "", "Device secret key")
+	identityJWKSCommand.Flags().BoolVarP(&includeRevoked, "include-revoked", "r", false, "Include revoked keys")
+	identityJWKSCommand.Flags().StringVarP(&atTime, "at", "t", "", "Output the keys that were valid at a point in time [unix timestamp, RFC3339]")
+	identityJWKSCommand.Flags().IntVarP(&atSequence, "at-sequence", "q", -1, "Output the keys that were valid as of an operation in the identities history")
+}
+
+// jwkInfo builds a json web key for a key on the signature graph,
+// annotated with its type, device and when it was created and revoked
+func jwkInfo(k *keyInfo) (map[string]interface{}, error) {
+	data, err := json.Marshal(jwk(newKey(keyTypePublic, k.KID, k.PublicKey), k.PublicKey))
+	if err != nil {
+		return nil, err
+	}
+
+	var jk map[string]interface{}
+
+	err = json.Unmarshal(data, &jk)
+	if err != nil {
+		return nil, err
+	}
+
+	jk["self_type"] = k.Type
+	jk["self_created_at"] = k.CreatedAt
+
+	if k.DID != "" {
+		jk["self_did"] = k.DID
+	}
+
+	if k.RevokedAt != 0 {
+		jk["self_revoked_at"] = k.RevokedAt
+	}
+
+	return jk, nil
+}
@@ -0,0 +1,143 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/joinself/self-go-sdk/pkg/siggraph"
+	"github.com/olekukonko/tablewriter"
+	"github.com/spf13/cobra"
+)
+
+var identityShowCommand = &cobra.Command{
+	Use:   "show [selfID]",
+	Short: "shows the public state of any identity",
+	Long:  "shows the type, advertised devices and valid keys of any identity, using the app identity's credentials to look it up. Revoked keys can optionally be included",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) < 1 {
+			check(errors.New("you must specify an identity to show [selfID]"))
+		}
+
+		if appID == "" {
+			check(errors.New("you must specify an app identity to authenticate as [--app-id]"))
+		}
+
+		sk := mustSecretKey(secretKey, keyTypeSecret, "secret key")
+
+		client := rest(cmd.Context(), appID, sk)
+
+		identity, sg := getIdentity(client, args[0])
+
+		done := make(chan error)
+
+		// get the devices
+		go log("getting devices", done)
+
+		devices, err := fetchDevices(client, args[0])
+		done <- err
+
+		if err != nil {
+			exit(1)
+		}
+
+		if len(identity.History) == 0 {
+			check(fmt.Errorf("identity %s does not have any history", args[0]))
+		}
+
+		keys, err := keyHistory(identity.History, sg)
+		check(err)
+
+		last, err := siggraph.ParseOperation(identity.History[len(identity.History)-1])
+		check(err)
+
+		sort.Strings(devices)
+
+		lines := identityKeyLines(keys, devices, time.Now(), includeRevoked)
+
+		advertised := "-"
+		if len(devices) > 0 {
+			advertised = strings.Join(devices, ", ")
+		}
+
+		progressf("\n")
+		fmt.Println("self id:       ", args[0])
+		fmt.Println("type:          ", identity.Type)
+		fmt.Println("sequence:      ", len(identity.History)-1)
+		fmt.Println("updated:       ", formatTime(seconds(last.Timestamp)))
+		fmt.Println("devices:       ", advertised)
+		fmt.Println("")
+
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader([]string{"KID", "DID", "TYPE", "STATE", "ACTIVE", "CREATED", "REVOKED", "PUBLIC KEY"})
+		table.SetAlignment(tablewriter.ALIGN_LEFT)
+		table.SetAutoWrapText(false)
+		table.SetHeaderLine(false)
+		table.SetRowLine(false)
+		table.SetBorder(false)
+		table.SetCenterSeparator("")
+		table.SetColumnSeparator("")
+		table.AppendBulk(lines)
+		table.Render()
+	},
+}
+
+func init() {
+	identityCommand.AddCommand(identityShowCommand)
+	identityShowCommand.Flags().StringVarP(&secretKey, "secret-key", "s", "", "Device secret key of the app identity")
+	identityShowCommand.Flags().StringVarP(&appID, "app-id", "a", "", "App identity to authenticate as")
+	identityShowCommand.Flags().BoolVarP(&includeRevoked, "include-revoked", "r", false, "Include revoked keys")
+}
+
+// identityKeyLines builds the table lines for an identities keys at a point in
+// time. Keys that have been revoked are only included if includeRevoked is set
+func identityKeyLines(keys []*keyInfo, devices []string, at time.Time, includeRevoked bool) [][]string {
+	var lines [][]string
+
+	for _, k := range keys {
+		state := k.stateAt(at)
+
+		if state == keyStateRevoked && !includeRevoked {
+			continue
+		}
+
+		did := k.DID
+		active := "-"
+
+		if k.Type == siggraph.TypeDeviceKey {
+			active = paint(os.Stdout, colorRed, "✘")
+			if contains(devices, k.DID) {
+				active = paint(os.Stdout, colorGreen, "✓")
+			}
+		} else {
+			did = "-"
+		}
+
+		switch state {
+		case keyStateValid:
+			state = paint(os.Stdout, colorGreen, state)
+		case keyStatePending:
+			state = paint(os.Stdout, colorYellow, state)
+		default:
+			state = paint(os.Stdout, colorRed, state)
+		}
+
+		lines = append(lines, []string{
+			k.KID,
+			did,
+			k.Type,
+			state,
+			active,
+			formatTime(k.CreatedAt),
+			formatTime(k.RevokedAt),
+			enc.EncodeToString(k.PublicKey),
+		})
+	}
+
+	return lines
+}
@@ -0,0 +1,81 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package cmd
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/joinself/self-go-sdk/pkg/siggraph"
+)
+
+func TestIdentityKeyLines(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+
+	ti := newTestIdentity(t, "app", now.Add(-2*time.Hour), "1", "2")
+
+	revokedAt := now.Add(-30 * time.Minute)
+
+	ti.add(t, ti.keys["1"], revokedAt, siggraph.Action{
+		KID:           "2",
+		DID:           "2",
+		Type:          siggraph.TypeDeviceKey,
+		Action:        siggraph.ActionKeyRevoke,
+		EffectiveFrom: revokedAt.Unix(),
+	})
+
+	nk := generateKey(keyTypeSecret, "4")
+
+	ti.add(t, ti.keys["1"], now.Add(-10*time.Minute), siggraph.Action{
+		KID:           nk.kid,
+		DID:           "3",
+		Type:          siggraph.TypeDeviceKey,
+		Action:        siggraph.ActionKeyAdd,
+		EffectiveFrom: now.Add(time.Hour).Unix(),
+		Key:           enc.EncodeToString(nk.publicKey()),
+	})
+
+	keys, err := keyHistory(ti.history, ti.graph(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	created := formatTime(now.Add(-2 * time.Hour).Unix())
+
+	line := func(kid, did, typ, state, active, createdAt, revoked string, pk []byte) string {
+		return strings.Join([]string{kid, did, typ, state, active, createdAt, revoked, enc.EncodeToString(pk)}, " ")
+	}
+
+	valid := []string{
+		line("1", "1", siggraph.TypeDeviceKey, keyStateValid, "✓", created, "-", ti.keys["1"].publicKey()),
+		line("3", "-", siggraph.TypeRecoveryKey, keyStateValid, "-", created, "-", ti.keys["3"].publicKey()),
+		line("4", "3", siggraph.TypeDeviceKey, keyStatePending, "✘", formatTime(now.Add(-10*time.Minute).Unix()), "-", nk.publicKey()),
+	}
+
+	revoked := line("2", "2", siggraph.TypeDeviceKey, keyStateRevoked, "✓", created, formatTime(revokedAt.Unix()), ti.keys["2"].publicKey())
+
+	tests := []struct {
+		name           string
+		includeRevoked bool
+		expected       []string
+	}{
+		{"valid keys", false, valid},
+		{"include revoked", true, []string{valid[0], revoked, valid[1], valid[2]}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var lines []string
+
+			// device 2 is still advertised after its key was revoked
+			for _, l := range identityKeyLines(keys, []string{"1", "2"}, now, tc.includeRevoked) {
+				lines = append(lines, strings.Join(l, " "))
+			}
+
+			if strings.Join(lines, "\n") != strings.Join(tc.expected, "\n") {
+				t.Fatalf("expected lines:\n%s\ngot:\n%s", strings.Join(tc.expected, "\n"), strings.Join(lines, "\n"))
+			}
+		})
+	}
+}
@@ -0,0 +1,281 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"sort"
+	"time"
+
+	"github.com/joinself/self-go-sdk/pkg/siggraph"
+	"github.com/joinself/self-go-sdk/pkg/transport"
+	"github.com/spf13/cobra"
+)
+
+const (
+	eventKeyAdded          = "key.added"
+	eventKeyRevoked        = "key.revoked"
+	eventDeviceActivated   = "device.activated"
+	eventDeviceDeactivated = "device.deactivated"
+	eventHistoryChanged    = "history.changed"
+)
+
+var (
+	webhookURL string
+	execHook   string
+	stateFile  string
+
+	webhookClient = &http.Client{Timeout: 10 * time.Second}
+)
+
+// watchEvent an event emitted when an identity changes
+type watchEvent struct {
+	Time          time.Time `json:"time"`
+	SelfID        string    `json:"self_id"`
+	Event         string    `json:"event"`
+	KID           string    `json:"kid,omitempty"`
+	DID           string    `json:"did,omitempty"`
+	Type          string    `json:"type,omitempty"`
+	Sequence      int       `json:"sequence,omitempty"`
+	SignedBy      string    `json:"signed_by,omitempty"`
+	EffectiveFrom int64     `json:"effective_from,omitempty"`
+}
+
+// watchState the last seen state of an identity
+type watchState struct {
+	Sequence  int      `json:"sequence"`
+	Signature string   `json:"signature"`
+	Devices   []string `json:"devices"`
+}
+
+var identityWatchCommand = &cobra.Command{
+	Use:   "watch",
+	Short: "watches identities for changes to their keys and devices",
+	Long:  "polls identities for changes to their signature graph and devices, emitting an event as a json line, webhook or to a command whenever a key is added or revoked, or a device is activated or deactivated",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) < 1 {
+			check(errors.New("you must specify at least one identity [selfID...]"))
+		}
+
+		sk := mustSecretKey(secretKey, keyTypeSecret, "secret key")
+
+		if appID == "" {
+			appID = args[0]
+		}
+
+		client := rest(cmd.Context(), appID, sk)
+
+		state := make(map[string]*watchState)
+
+		if stateFile != "" {
+			data, err := os.ReadFile(stateFile)
+			if err == nil {
+				err = json.Unmarshal(data, &state)
+			}
+
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				check(fmt.Errorf("failed to load state file: %w", err))
+			}
+		}
+
+		for {
+			for _, selfID := range args {
+				events, current, err := watchIdentity(client, selfID, state[selfID])
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "failed to check identity '%s': %s\n", selfID, err.Error())
+					continue
+				}
+
+				state[selfID] = current
+
+				for _, e := range events {
+					emit(e)
+				}
+			}
+
+			if stateFile != "" {
+				data, err := json.Marshal(state)
+				if err == nil {
+					err = os.WriteFile(stateFile, data, 0600)
+				}
+
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "failed to save state file: %s\n", err.Error())
+				}
+			}
+
+			if !sleep(cmd.Context(), pollInterval) {
+				return
+			}
+		}
+	},
+}
+
+func init() {
+	identityCommand.AddCommand(identityWatchCommand)
+	identityWatchCommand.Flags().StringVarP(&secretKey, "secret-key", "s", "", "Device secret key")
+	identityWatchCommand.Flags().StringVarP(&appID, "app-id", "a", "", "App identity to authenticate as, defaults to the first identity being watched")
+	identityWatchCommand.Flags().DurationVarP(&pollInterval, "interval", "i", time.Minute, "Interval to check identities at")
+	identityWatchCommand.Flags().StringVarP(&webhookURL, "webhook", "w", "", "URL to post events to")
+	identityWatchCommand.Flags().StringVarP(&execHook, "exec", "e", "", "Command to run for each event, with the event on stdin")
+	identityWatchCommand.Flags().StringVarP(&stateFile, "state", "S", "", "File to persist the last seen state of each identity to")
+}
+
+// watchIdentity fetches an identities history and devices, returning events for
+// any changes since the previous state. If there is no previous state, no events
+// are returned
+func watchIdentity(client *transport.Rest, selfID string, previous *watchState) ([]watchEvent, *watchState, error) {
+	identity, _, err := fetchIdentity(client, selfID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	devices, err := fetchDevices(client, selfID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sort.Strings(devices)
+
+	if len(identity.History) == 0 {
+		return nil, nil, fmt.Errorf("identity %s does not have any history", selfID)
+	}
+
+	var last siggraph.JWS
+
+	err = json.Unmarshal(identity.History[len(identity.History)-1], &last)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	current := &watchState{
+		Sequence:  len(identity.History) - 1,
+		Signature: last.Signature,
+		Devices:   devices,
+	}
+
+	if previous == nil {
+		return nil, current, nil
+	}
+
+	var events []watchEvent
+
+	now := time.Now().UTC()
+
+	// check the operations we have already seen have not changed
+	changed := previous.Sequence > current.Sequence
+
+	if !changed {
+		var seen siggraph.JWS
+
+		err = json.Unmarshal(identity.History[previous.Sequence], &seen)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		changed = seen.Signature != previous.Signature
+	}
+
+	if changed {
+		events = append(events, watchEvent{Time: now, SelfID: selfID, Event: eventHistoryChanged, Sequence: current.Sequence})
+	} else {
+		for _, operation := range identity.History[previous.Sequence+1:] {
+			op, err := siggraph.ParseOperation(operation)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			for _, a := range op.Actions {
+				e := watchEvent{
+					Time:          now,
+					SelfID:        selfID,
+					Event:         eventKeyAdded,
+					KID:           a.KID,
+					DID:           a.DID,
+					Type:          a.Type,
+					Sequence:      op.Sequence,
+					SignedBy:      op.SignatureKeyID(),
+					EffectiveFrom: seconds(a.EffectiveFrom),
+				}
+
+				if a.Action == siggraph.ActionKeyRevoke {
+					e.Event = eventKeyRevoked
+				}
+
+				events = append(events, e)
+			}
+		}
+	}
+
+	for _, did := range difference(current.Devices, previous.Devices) {
+		events = append(events, watchEvent{Time: now, SelfID: selfID, Event: eventDeviceActivated, DID: did})
+	}
+
+	for _, did := range difference(previous.Devices, current.Devices) {
+		events = append(events, watchEvent{Time: now, SelfID: selfID, Event: eventDeviceDeactivated, DID: did})
+	}
+
+	return events, current, nil
+}
+
+// emit writes an event to stdout as a json line, and sends it to the webhook and
+// command if they have been configured
+func emit(e watchEvent) {
+	data, err := json.Marshal(e)
+	check(err)
+
+	fmt.Println(string(data))
+
+	if webhookURL != "" {
+		resp, err := webhookClient.Post(webhookURL, "application/json", bytes.NewReader(data))
+		if err == nil {
+			resp.Body.Close()
+
+			if resp.StatusCode >= 300 {
+				err = errors.New(resp.Status)
+			}
+		}
+
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to send event to webhook: %s\n", err.Error())
+		}
+	}
+
+	if execHook != "" {
+		hook := exec.Command("sh", "-c", execHook)
+		hook.Stdin = bytes.NewReader(data)
+		hook.Stdout = os.Stderr
+		hook.Stderr = os.Stderr
+		hook.Env = append(os.Environ(), "SELF_EVENT="+e.Event, "SELF_ID="+e.SelfID)
+
+		err = hook.Run()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to run exec hook: %s\n", err.Error())
+		}
+	}
+}
+
+// difference returns the values in a that are not in b
+func difference(a, b []string) []string {
+	seen := make(map[string]struct{})
+
+	for _, v := range b {
+		seen[v] = struct{}{}
+	}
+
+	var diff []string
+
+	for _, v := range a {
+		if _, ok := seen[v]; !ok {
+			diff = append(diff, v)
+		}
+	}
+
+	return diff
+}
@@ -0,0 +1,143 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/joinself/self-go-sdk/pkg/siggraph"
+)
+
+func TestWatchIdentityEmptyHistory(t *testing.T) {
+	client := testRest(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/identities/app":
+			w.Write([]byte(`{"self_id": "app", "type": "app", "history": []}`))
+		case "/v1/identities/app/devices":
+			w.Write([]byte(`[]`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+
+	_, _, err := watchIdentity(client, "app", nil)
+	if err == nil {
+		t.Fatal("expected an error for an identity without any history")
+	}
+}
+
+// formatEvents formats watch events for comparison
+func formatEvents(events []watchEvent) string {
+	var lines []string
+
+	for _, e := range events {
+		lines = append(lines, fmt.Sprintf("%s %q %q %d %q", e.Event, e.KID, e.DID, e.Sequence, e.SignedBy))
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func TestWatchIdentity(t *testing.T) {
+	now := time.Now()
+
+	ti := newTestIdentity(t, "app", now.Add(-time.Hour), "1", "2")
+
+	client := testRest(t, ti)
+
+	nk := generateKey(keyTypeSecret, "4")
+
+	tests := []struct {
+		name     string
+		change   func()
+		expected []string
+	}{
+		{
+			name:     "first poll",
+			expected: nil,
+		},
+		{
+			name:     "no change",
+			expected: nil,
+		},
+		{
+			name: "keys added and revoked",
+			change: func() {
+				ti.add(t, ti.keys["1"], now.Add(-30*time.Minute),
+					siggraph.Action{
+						KID:           "2",
+						DID:           "2",
+						Type:          siggraph.TypeDeviceKey,
+						Action:        siggraph.ActionKeyRevoke,
+						EffectiveFrom: now.Add(-30 * time.Minute).Unix(),
+					},
+					siggraph.Action{
+						KID:           nk.kid,
+						DID:           "3",
+						Type:          siggraph.TypeDeviceKey,
+						Action:        siggraph.ActionKeyAdd,
+						EffectiveFrom: now.Add(-30 * time.Minute).Unix(),
+						Key:           enc.EncodeToString(nk.publicKey()),
+					},
+				)
+			},
+			expected: []string{
+				`key.revoked "2" "2" 1 "1"`,
+				`key.added "4" "3" 1 "1"`,
+			},
+		},
+		{
+			name: "devices activated and deactivated",
+			change: func() {
+				ti.devices = []string{"3", "1"}
+			},
+			expected: []string{
+				`device.activated "" "3" 0 ""`,
+				`device.deactivated "" "2" 0 ""`,
+			},
+		},
+		{
+			name:     "no change after changes",
+			expected: nil,
+		},
+		{
+			name: "history changed",
+			change: func() {
+				ti.history = ti.history[:1]
+			},
+			expected: []string{
+				`history.changed "" "" 0 ""`,
+			},
+		},
+	}
+
+	var state *watchState
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.change != nil {
+				tc.change()
+			}
+
+			events, current, err := watchIdentity(client, "app", state)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			state = current
+
+			if formatEvents(events) != strings.Join(tc.expected, "\n") {
+				t.Fatalf("expected events:\n%s\ngot:\n%s", strings.Join(tc.expected, "\n"), formatEvents(events))
+			}
+
+			for _, e := range events {
+				if e.SelfID != "app" {
+					t.Fatalf("expected event to be for identity app, got %s", e.SelfID)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,197 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/spf13/viper"
+)
+
+// inventory a set of identities to operate on
+type inventory struct {
+	KeySink    string              `mapstructure:"key_sink"`
+	Identities []inventoryIdentity `mapstructure:"identities"`
+}
+
+// inventoryIdentity an identity in an inventory. The identities secret key can
+// be referenced from a file or environment variable, or provided inline
+type inventoryIdentity struct {
+	SelfID        string `mapstructure:"self_id"`
+	SecretKey     string `mapstructure:"secret_key"`
+	SecretKeyFile string `mapstructure:"secret_key_file"`
+	SecretKeyEnv  string `mapstructure:"secret_key_env"`
+}
+
+// inventoryResult the result of running a task against an identity in an inventory
+type inventoryResult struct {
+	SelfID string
+	Value  interface{}
+	Err    error
+}
+
+// loadInventory loads an inventory from a yaml, json or toml file
+func loadInventory(path string) (*inventory, error) {
+	iv := viper.New()
+	iv.SetConfigFile(path)
+
+	err := iv.ReadInConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	var inv inventory
+
+	err = iv.Unmarshal(&inv)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, i := range inv.Identities {
+		if i.SelfID == "" {
+			return nil, errors.New("inventory contains an identity without a self_id")
+		}
+	}
+
+	return &inv, nil
+}
+
+// mustLoadInventory loads an inventory from the file specified by the '--identities' flag
+func mustLoadInventory() *inventory {
+	inv, err := loadInventory(inventoryFile)
+	check(err)
+
+	if len(inv.Identities) < 1 {
+		check(errors.New("inventory does not contain any identities"))
+	}
+
+	return inv
+}
+
+// resolveKey resolves the identities secret key from its key reference,
+// falling back to the key specified by the '--secret-key' flag
+func (i inventoryIdentity) resolveKey() (*key, error) {
+	s := i.SecretKey
+
+	switch {
+	case i.SecretKeyFile != "":
+		data, err := os.ReadFile(i.SecretKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read secret key file: %w", err)
+		}
+
+		s = strings.TrimSpace(string(data))
+	case i.SecretKeyEnv != "":
+		s = os.Getenv(i.SecretKeyEnv)
+		if s == "" {
+			return nil, fmt.Errorf("secret key environment variable '%s' is not set", i.SecretKeyEnv)
+		}
+	case s == "":
+		s = secretKey
+	}
+
+	return parseSecretKey(s, keyTypeSecret, "secret key")
+}
+
+// updateKey replaces the identity's secret key with a new key. Only
+// keys referenced from a file can be updated
+func (i inventoryIdentity) updateKey(nk *key) error {
+	if nk == nil {
+		return errors.New("the new key can't be read back from the key sink")
+	}
+
+	if i.SecretKeyFile == "" {
+		return errors.New("only keys referenced by a secret_key_file can be updated")
+	}
+
+	err := os.WriteFile(i.SecretKeyFile, []byte(nk.String()+"\n"), 0600)
+	if err != nil {
+		return fmt.Errorf("failed to update secret key file: %w", err)
+	}
+
+	return nil
+}
+
+// forEachIdentity runs a task against every identity in the inventory, using a pool
+// of workers bounded by the '--concurrency' flag. Results are returned in the order
+// the identities are listed in the inventory. Identities that have not been started
+// when the context is cancelled are skipped
+func forEachIdentity(ctx context.Context, inv *inventory, task func(i inventoryIdentity, sk *key) (interface{}, error)) []inventoryResult {
+	results := make([]inventoryResult, len(inv.Identities))
+
+	workers := concurrency
+	if workers < 1 {
+		workers = 1
+	}
+
+	jobs := make(chan int)
+
+	var wg sync.WaitGroup
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for j := range jobs {
+				i := inv.Identities[j]
+
+				results[j].SelfID = i.SelfID
+
+				if ctx.Err() != nil {
+					results[j].Err = ctx.Err()
+					continue
+				}
+
+				sk, err := i.resolveKey()
+				if err != nil {
+					results[j].Err = err
+					continue
+				}
+
+				results[j].Value, results[j].Err = task(i, sk)
+			}
+		}()
+	}
+
+	for j := range inv.Identities {
+		jobs <- j
+	}
+
+	close(jobs)
+	wg.Wait()
+
+	return results
+}
+
+// reportErrors prints the errors encountered for each identity to
+// stderr, returning true if there were any errors
+func reportErrors(results []inventoryResult) bool {
+	var failed int
+
+	for _, r := range results {
+		if r.Err == nil {
+			continue
+		}
+
+		if failed == 0 {
+			fmt.Fprintln(os.Stderr, "")
+		}
+
+		failed++
+
+		errorf("%s %s: %s\n", paint(os.Stderr, colorRed, "✘"), r.SelfID, r.Err.Error())
+	}
+
+	if failed > 0 {
+		fmt.Fprintf(os.Stderr, "\n%d of %d identities failed\n", failed, len(results))
+	}
+
+	return failed > 0
+}
@@ -0,0 +1,83 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestForEachIdentity(t *testing.T) {
+	sk := generateKey(keyTypeSecret, "1")
+
+	inv := &inventory{
+		Identities: []inventoryIdentity{
+			{SelfID: "app1", SecretKey: sk.String()},
+			{SelfID: "app2", SecretKeyEnv: "SELF_CLI_TEST_UNSET_KEY"},
+			{SelfID: "app3", SecretKey: sk.String()},
+			{SelfID: "app4", SecretKey: sk.String()},
+		},
+	}
+
+	concurrency = 2
+
+	results := forEachIdentity(context.Background(), inv, func(i inventoryIdentity, sk *key) (interface{}, error) {
+		if i.SelfID == "app3" {
+			return nil, errors.New("failed")
+		}
+
+		return i.SelfID, nil
+	})
+
+	if len(results) != len(inv.Identities) {
+		t.Fatalf("expected %d results, got %d", len(inv.Identities), len(results))
+	}
+
+	for n, r := range results {
+		if r.SelfID != inv.Identities[n].SelfID {
+			t.Fatalf("expected result %d to be for %s, got %s", n, inv.Identities[n].SelfID, r.SelfID)
+		}
+
+		failed := r.SelfID == "app2" || r.SelfID == "app3"
+
+		if failed != (r.Err != nil) {
+			t.Fatalf("unexpected error for %s: %v", r.SelfID, r.Err)
+		}
+
+		if !failed && r.Value != r.SelfID {
+			t.Fatalf("unexpected value for %s: %v", r.SelfID, r.Value)
+		}
+	}
+}
+
+func TestForEachIdentityCancelled(t *testing.T) {
+	sk := generateKey(keyTypeSecret, "1")
+
+	inv := &inventory{
+		Identities: []inventoryIdentity{
+			{SelfID: "app1", SecretKey: sk.String()},
+			{SelfID: "app2", SecretKey: sk.String()},
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	var ran int
+
+	results := forEachIdentity(ctx, inv, func(i inventoryIdentity, sk *key) (interface{}, error) {
+		ran++
+		return nil, nil
+	})
+
+	if ran != 0 {
+		t.Fatalf("expected no tasks to run, %d ran", ran)
+	}
+
+	for _, r := range results {
+		if !errors.Is(r.Err, context.Canceled) {
+			t.Fatalf("expected %s to be skipped, got %v", r.SelfID, r.Err)
+		}
+	}
+}
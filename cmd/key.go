@@ -0,0 +1,21 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+var keyCommand = &cobra.Command{
+	Use:   "key",
+	Short: "the key command",
+	Run: func(cmd *cobra.Command, args []string) {
+		fmt.Println("key called")
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(keyCommand)
+}
@@ -0,0 +1,47 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+var keyExportCommand = &cobra.Command{
+	Use:   "export",
+	Short: "exports a key in a standard format",
+	Long:  "exports a device, recovery or public key as a jwk, pkcs#8/pkix pem or openssh key",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) < 1 {
+			check(errors.New("you must specify a key [key]"))
+		}
+
+		k, err := decodeKey(args[0])
+		if err != nil {
+			check(fmt.Errorf("the key provided is not valid: %w", err))
+		}
+
+		if k.kid == "" {
+			k.kid = keyID
+		}
+
+		data, err := exportKey(k, keyFormat, publicOnly)
+		check(err)
+
+		os.Stdout.Write(data)
+
+		if keyFormat == formatJWK {
+			fmt.Println("")
+		}
+	},
+}
+
+func init() {
+	keyCommand.AddCommand(keyExportCommand)
+	keyExportCommand.Flags().StringVarP(&keyFormat, "format", "f", formatJWK, "Format to export the key as [jwk, pem, openssh]")
+	keyExportCommand.Flags().StringVarP(&keyID, "kid", "k", "", "Key identifier to use if the key does not contain one")
+	keyExportCommand.Flags().BoolVarP(&publicOnly, "public", "p", false, "Only export the public key")
+}
@@ -0,0 +1,47 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+var keyGenerateCommand = &cobra.Command{
+	Use:   "generate",
+	Short: "generates a new key",
+	Long:  "generates a new device or recovery key. The public key can be registered with 'device create --device-public-key'",
+	Run: func(cmd *cobra.Command, args []string) {
+		var typ string
+
+		switch keyType {
+		case "device":
+			typ = keyTypeSecret
+		case "recovery":
+			typ = keyTypeRecovery
+		default:
+			check(errors.New("key type must be one of [device, recovery]"))
+		}
+
+		k := generateKey(typ, keyID)
+
+		fmt.Println("private key:  ", k)
+		if typ == keyTypeSecret && keyID != "" {
+			fmt.Println("sdk secret:   ", k.Legacy())
+		}
+		fmt.Println("public key:   ", k.public().Legacy())
+
+		if keyID == "" {
+			progressf("\nthe key has no key identifier, once the public key has been registered\n")
+			progressf("run 'self-cli key inspect --kid [kid] [private key]' to assign it one\n")
+		}
+	},
+}
+
+func init() {
+	keyCommand.AddCommand(keyGenerateCommand)
+	keyGenerateCommand.Flags().StringVarP(&keyType, "type", "t", "device", "Type of key to generate [device, recovery]")
+	keyGenerateCommand.Flags().StringVarP(&keyID, "kid", "k", "", "Key identifier to embed in the key")
+}
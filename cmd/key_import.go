@@ -0,0 +1,65 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+var keyImportCommand = &cobra.Command{
+	Use:   "import",
+	Short: "imports a key from a standard format",
+	Long:  "imports a jwk, pkcs#8/pkix pem or openssh key from a file, or from stdin if the file is '-' or omitted",
+	Run: func(cmd *cobra.Command, args []string) {
+		var typ string
+
+		switch keyType {
+		case "device":
+			typ = keyTypeSecret
+		case "recovery":
+			typ = keyTypeRecovery
+		default:
+			check(errors.New("key type must be one of [device, recovery]"))
+		}
+
+		var path string
+		if len(args) > 0 {
+			path = args[0]
+		}
+
+		data, err := readInput(path)
+		check(err)
+
+		k, err := importKey(data, keyFormat, typ)
+		if err != nil {
+			check(fmt.Errorf("the key provided could not be imported: %w", err))
+		}
+
+		if k.kid == "" {
+			k.kid = keyID
+		}
+
+		if k.isSecret() {
+			fmt.Println("private key:  ", k)
+			if k.typ == keyTypeSecret && k.kid != "" {
+				fmt.Println("sdk secret:   ", k.Legacy())
+			}
+		}
+
+		fmt.Println("public key:   ", k.public().Legacy())
+
+		if !k.isSecret() {
+			fmt.Println("encoded:      ", k)
+		}
+	},
+}
+
+func init() {
+	keyCommand.AddCommand(keyImportCommand)
+	keyImportCommand.Flags().StringVarP(&keyFormat, "format", "f", formatJWK, "Format of the key to import [jwk, pem, openssh]")
+	keyImportCommand.Flags().StringVarP(&keyType, "type", "t", "device", "Type of secret key being imported [device, recovery]")
+	keyImportCommand.Flags().StringVarP(&keyID, "kid", "k", "", "Key identifier to use if the key does not contain one")
+}
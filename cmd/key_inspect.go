@@ -0,0 +1,73 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+var keyInspectCommand = &cobra.Command{
+	Use:   "inspect",
+	Short: "decodes and validates a key",
+	Long:  "decodes and validates a key in either the prefixed or legacy format. If a key identifier is provided, the key will be re-encoded with it",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) < 1 {
+			check(errors.New("you must specify a key [key]"))
+		}
+
+		k, err := decodeKey(args[0])
+		if err != nil {
+			check(fmt.Errorf("the key provided is not valid: %w", err))
+		}
+
+		if keyID != "" {
+			k.kid = keyID
+		}
+
+		typ := "public key"
+
+		switch k.typ {
+		case keyTypeSecret:
+			typ = "device secret key"
+		case keyTypeRecovery:
+			typ = "recovery secret key"
+		case "":
+			typ = "device or recovery secret key"
+		}
+
+		format := "prefixed"
+		if k.legacy {
+			format = "legacy"
+		}
+
+		kid := k.kid
+		if kid == "" {
+			kid = "-"
+		}
+
+		fmt.Println("type:         ", typ)
+		fmt.Println("format:       ", format)
+		fmt.Println("key id:       ", kid)
+		fmt.Println("public key:   ", k.public().Legacy())
+
+		if k.typ == "" {
+			// the type of legacy secret keys is unknown, so they can't be
+			// re-encoded unless prefixed with 'sk_' or 'rk_'
+			return
+		}
+
+		fmt.Println("encoded:      ", k)
+
+		if k.typ == keyTypeSecret && k.kid != "" {
+			fmt.Println("sdk secret:   ", k.Legacy())
+		}
+	},
+}
+
+func init() {
+	keyCommand.AddCommand(keyInspectCommand)
+	keyInspectCommand.Flags().StringVarP(&keyID, "kid", "k", "", "Key identifier to assign to the key")
+}
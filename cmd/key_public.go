@@ -0,0 +1,36 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+var keyPublicCommand = &cobra.Command{
+	Use:   "public",
+	Short: "derives the public key of a secret key",
+	Long:  "derives the public key of a device or recovery secret key, in the encoding expected by the api",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) < 1 {
+			check(errors.New("you must specify a secret key [secretKey]"))
+		}
+
+		k, err := decodeKey(args[0])
+		if err != nil {
+			check(fmt.Errorf("the secret key provided is not valid: %w", err))
+		}
+
+		if !k.isSecret() {
+			check(fmt.Errorf("the secret key provided is not valid: %w", errKeyNotSecret))
+		}
+
+		fmt.Println(k.public().Legacy())
+	},
+}
+
+func init() {
+	keyCommand.AddCommand(keyPublicCommand)
+}
@@ -0,0 +1,182 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package cmd
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+)
+
+// outputValue returns the value of a labelled line of a commands output
+func outputValue(t *testing.T, out, label string) string {
+	t.Helper()
+
+	for _, line := range strings.Split(out, "\n") {
+		if strings.HasPrefix(line, label+":") {
+			return strings.TrimSpace(strings.TrimPrefix(line, label+":"))
+		}
+	}
+
+	t.Fatalf("expected output to contain '%s', got:\n%s", label, out)
+
+	return ""
+}
+
+// runKeyCommand runs a key command with the given kid and type, returning its output
+func runKeyCommand(t *testing.T, typ, kid string, run func()) string {
+	t.Helper()
+
+	previousType, previousKID := keyType, keyID
+	defer func() { keyType, keyID = previousType, previousKID }()
+
+	keyType, keyID = typ, kid
+
+	return captureOutput(t, &os.Stdout, run)
+}
+
+func TestKeyGenerate(t *testing.T) {
+	tests := []struct {
+		name   string
+		typ    string
+		kid    string
+		keyTyp string
+		sdk    bool
+	}{
+		{"device", "device", "3", keyTypeSecret, true},
+		{"device without kid", "device", "", keyTypeSecret, false},
+		{"recovery", "recovery", "4", keyTypeRecovery, false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			out := runKeyCommand(t, tc.typ, tc.kid, func() {
+				keyGenerateCommand.Run(keyGenerateCommand, nil)
+			})
+
+			sk, err := decodeKey(outputValue(t, out, "private key"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if sk.typ != tc.keyTyp || sk.kid != tc.kid || sk.legacy {
+				t.Fatalf("expected a %s key %s, got %s key %s", tc.keyTyp, tc.kid, sk.typ, sk.kid)
+			}
+
+			pk, err := decodeKey(outputValue(t, out, "public key"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if pk.typ != keyTypePublic || !bytes.Equal(pk.data, sk.publicKey()) {
+				t.Fatal("expected the public key to be derived from the private key")
+			}
+
+			if !tc.sdk {
+				if strings.Contains(out, "sdk secret:") {
+					t.Fatalf("expected no sdk secret, got:\n%s", out)
+				}
+				return
+			}
+
+			legacy, err := decodeKey(outputValue(t, out, "sdk secret"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !legacy.legacy || legacy.kid != tc.kid || !bytes.Equal(legacy.data, sk.data) {
+				t.Fatal("expected the sdk secret to encode the private key")
+			}
+		})
+	}
+}
+
+func TestKeyPublic(t *testing.T) {
+	sk := generateKey(keyTypeSecret, "1")
+	rk := generateKey(keyTypeRecovery, "2")
+
+	tests := []struct {
+		name string
+		key  *key
+		arg  string
+	}{
+		{"secret", sk, sk.String()},
+		{"recovery", rk, rk.String()},
+		{"sdk secret", sk, sk.Legacy()},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			out := runKeyCommand(t, "", "", func() {
+				keyPublicCommand.Run(keyPublicCommand, []string{tc.arg})
+			})
+
+			pk, err := decodeKey(out)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// the api expects public keys without a prefix
+			if strings.TrimSpace(out) != enc.EncodeToString(tc.key.publicKey()) || !bytes.Equal(pk.data, tc.key.publicKey()) {
+				t.Fatalf("expected public key %s, got %s", enc.EncodeToString(tc.key.publicKey()), out)
+			}
+		})
+	}
+}
+
+func TestKeyInspect(t *testing.T) {
+	sk := generateKey(keyTypeSecret, "1")
+	unassigned := newKey(keyTypeSecret, "", sk.data)
+
+	tests := []struct {
+		name    string
+		arg     string
+		kid     string
+		typ     string
+		format  string
+		encoded string
+	}{
+		{"secret", sk.String(), "", "device secret key", "prefixed", sk.String()},
+		{"assign kid", unassigned.String(), "1", "device secret key", "prefixed", sk.String()},
+		{"sdk secret", sk.Legacy(), "", "device or recovery secret key", "legacy", ""},
+		{"public", sk.public().String(), "", "public key", "prefixed", sk.public().String()},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			out := runKeyCommand(t, "", tc.kid, func() {
+				keyInspectCommand.Run(keyInspectCommand, []string{tc.arg})
+			})
+
+			if outputValue(t, out, "type") != tc.typ || outputValue(t, out, "format") != tc.format {
+				t.Fatalf("expected a %s %s, got:\n%s", tc.format, tc.typ, out)
+			}
+
+			if outputValue(t, out, "public key") != sk.public().Legacy() {
+				t.Fatalf("expected public key %s, got:\n%s", sk.public().Legacy(), out)
+			}
+
+			if tc.encoded == "" {
+				if strings.Contains(out, "encoded:") {
+					t.Fatalf("expected a legacy key of unknown type not to be re-encoded, got:\n%s", out)
+				}
+				return
+			}
+
+			encoded := outputValue(t, out, "encoded")
+			if encoded != tc.encoded {
+				t.Fatalf("expected the key to be encoded as %s, got %s", tc.encoded, encoded)
+			}
+
+			k, err := decodeKey(encoded)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if k.public().Legacy() != sk.public().Legacy() {
+				t.Fatal("expected the encoded key to decode to the inspected key")
+			}
+		})
+	}
+}
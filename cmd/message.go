@@ -0,0 +1,393 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package cmd
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/joinself/self-go-sdk/pkg/ntp"
+	"github.com/joinself/self-go-sdk/pkg/siggraph"
+	"github.com/joinself/self-go-sdk/pkg/transport"
+	"github.com/spf13/cobra"
+	"github.com/square/go-jose"
+)
+
+const (
+	// messageTypeChat the type of messages sent by the sdk's chat service
+	messageTypeChat = "chat.message"
+	// messageTypePing the type of messages sent by 'device ping'
+	messageTypePing = "cli.ping"
+	// messageTypePong the type of messages sent in reply to a ping
+	messageTypePong = "cli.pong"
+
+	// messageExpiry how long messages are valid for
+	messageExpiry = time.Hour
+)
+
+var (
+	storageDir   string
+	storageKey   string
+	recipientKey string
+	messageType  string
+	messageCount int
+	messageWait  time.Duration
+	messageTypes []string
+	pingWait     time.Duration
+	messageAddr  string
+)
+
+// messagingClient a client connected to self messaging, as implemented by the sdk's messaging client
+type messagingClient interface {
+	Send(recipients []string, mtype string, plaintext []byte) error
+	Subscribe(msgType string, sub func(sender string, payload []byte))
+	Close() error
+}
+
+// messagingConfig the configuration for connecting a device to self messaging
+type messagingConfig struct {
+	SelfID       string
+	DeviceID     string
+	Key          *key
+	MessagingURL string
+	OffsetDir    string
+	CryptoDir    string
+	StorageKey   string
+	API          *transport.Rest
+}
+
+// messagingDevice one of an identities devices, connected to self messaging
+type messagingDevice struct {
+	ctx    context.Context
+	client messagingClient
+	api    *transport.Rest
+	selfID string
+	did    string
+	sk     *key
+}
+
+// receivedMessage a message received from self messaging
+type receivedMessage struct {
+	Time     time.Time              `json:"time"`
+	Sender   string                 `json:"sender"`
+	Type     string                 `json:"type"`
+	Verified bool                   `json:"verified"`
+	Payload  map[string]interface{} `json:"payload"`
+}
+
+var messageCommand = &cobra.Command{
+	Use:   "message",
+	Short: "the message command",
+	Run: func(cmd *cobra.Command, args []string) {
+		fmt.Println("message called")
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(messageCommand)
+}
+
+// addMessagingFlags adds the flags for storing a devices messaging state to a command
+func addMessagingFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&storageDir, "storage-dir", "", "Directory to store messaging sessions in, using the sdk's storage layout (default is $HOME/.self-cli/storage)")
+	cmd.Flags().StringVar(&storageKey, "storage-key", "", "Key to encrypt stored messaging sessions with (default is derived from the device's secret key)")
+}
+
+// connectDevice connects the device that a secret key belongs to to self messaging,
+// or to a stand-in messaging server if the messaging url is 'http://' or 'https://'.
+// Messaging sessions are stored using the sdk's storage layout, so that an app's
+// storage can be used by specifying its storage directory and key
+func connectDevice(ctx context.Context, selfID string, sk *key) (*messagingDevice, error) {
+	api := rest(ctx, selfID, sk)
+
+	did, err := deviceForKey(api, selfID, sk)
+	if err != nil {
+		return nil, err
+	}
+
+	url, err := messagingURL()
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := messagingConfig{
+		SelfID:       selfID,
+		DeviceID:     did,
+		Key:          sk,
+		MessagingURL: url,
+		API:          api,
+	}
+
+	var client messagingClient
+
+	if isStandInURL(url) {
+		client = newStandInClient(cfg)
+	} else {
+		client, err = newSDKMessagingClient(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect device %s to messaging: %w", did, err)
+		}
+	}
+
+	return &messagingDevice{
+		ctx:    ctx,
+		client: client,
+		api:    api,
+		selfID: selfID,
+		did:    did,
+		sk:     sk,
+	}, nil
+}
+
+// newSDKMessagingClient connects a device to self messaging with the sdk's
+// messaging client. Messaging sessions are stored using the sdk's storage layout
+func newSDKMessagingClient(cfg messagingConfig) (messagingClient, error) {
+	dir := storageDir
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+
+		dir = filepath.Join(home, ".self-cli", "storage")
+	}
+
+	cfg.OffsetDir = filepath.Join(dir, "apps", cfg.SelfID, "devices", cfg.DeviceID)
+	cfg.CryptoDir = filepath.Join(cfg.OffsetDir, "keys", cfg.Key.kid)
+
+	err := os.MkdirAll(cfg.CryptoDir, 0700)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.StorageKey = storageKey
+	if cfg.StorageKey == "" {
+		h := sha256.Sum256(append([]byte("self-cli storage key:"), cfg.Key.data...))
+		cfg.StorageKey = enc.EncodeToString(h[:])
+	}
+
+	return newMessagingClient(cfg)
+}
+
+// deviceForKey returns the id of the device a secret key belongs to
+func deviceForKey(client *transport.Rest, selfID string, sk *key) (string, error) {
+	identity, sg, err := fetchIdentity(client, selfID)
+	if err != nil {
+		return "", err
+	}
+
+	keys, err := keyHistory(identity.History, sg)
+	if err != nil {
+		return "", err
+	}
+
+	for _, k := range keys {
+		if k.KID != sk.kid || k.Type != siggraph.TypeDeviceKey {
+			continue
+		}
+
+		if k.stateAt(time.Now()) != keyStateValid {
+			return "", fmt.Errorf("key %s of device %s is not valid", k.KID, k.DID)
+		}
+
+		return k.DID, nil
+	}
+
+	return "", fmt.Errorf("key %s is not a device key of %s", sk.kid, selfID)
+}
+
+// address returns the messaging address of the device
+func (d *messagingDevice) address() string {
+	return d.selfID + ":" + d.did
+}
+
+// send sends a signed message to the recipients, which are either an identity,
+// to send to all of its advertised devices, or a specific device '<selfID>:<deviceID>'
+func (d *messagingDevice) send(recipient, typ string, fields map[string]interface{}) ([]string, error) {
+	var recipients []string
+
+	parts := strings.SplitN(recipient, ":", 2)
+
+	if len(parts) == 2 {
+		recipients = []string{recipient}
+	} else {
+		devices, err := fetchDevices(d.api, recipient)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, did := range devices {
+			if recipient+":"+did != d.address() {
+				recipients = append(recipients, recipient+":"+did)
+			}
+		}
+	}
+
+	if len(recipients) < 1 {
+		return nil, fmt.Errorf("%s does not advertise any devices to send to", recipient)
+	}
+
+	now := ntp.TimeFunc()
+
+	msg := map[string]interface{}{
+		"typ":       typ,
+		"jti":       uuid.New().String(),
+		"cid":       uuid.New().String(),
+		"iss":       d.selfID,
+		"sub":       parts[0],
+		"aud":       parts[0],
+		"iat":       now.Format(time.RFC3339),
+		"exp":       now.Add(messageExpiry).Format(time.RFC3339),
+		"device_id": d.did,
+	}
+
+	for k, v := range fields {
+		msg[k] = v
+	}
+
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	jws, err := signJWS(d.sk, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	err = d.client.Send(recipients, typ, []byte(jws.FullSerialize()))
+	if err != nil {
+		return nil, err
+	}
+
+	return recipients, nil
+}
+
+// subscribe receives messages of the given types, verifying their signature against
+// the sender's signature graph. Messages are no longer delivered once the device's
+// context is cancelled, so that the client is not blocked if they are not received
+func (d *messagingDevice) subscribe(types []string, received chan *receivedMessage) {
+	for _, typ := range types {
+		d.client.Subscribe(typ, func(sender string, plaintext []byte) {
+			m := d.parseMessage(sender, plaintext)
+
+			select {
+			case received <- m:
+			case <-d.ctx.Done():
+			}
+		})
+	}
+}
+
+// parseMessage decodes a received message and verifies its signature
+func (d *messagingDevice) parseMessage(sender string, plaintext []byte) *receivedMessage {
+	m := &receivedMessage{
+		Time:   time.Now(),
+		Sender: sender,
+	}
+
+	jws, err := jose.ParseSigned(string(plaintext))
+	if err != nil || len(jws.Signatures) != 1 {
+		return m
+	}
+
+	err = json.Unmarshal(jws.UnsafePayloadWithoutVerification(), &m.Payload)
+	if err != nil {
+		return m
+	}
+
+	m.Type, _ = m.Payload["typ"].(string)
+
+	identity, sg, err := fetchIdentity(d.api, strings.Split(sender, ":")[0])
+	if err != nil {
+		return m
+	}
+
+	m.Verified = verifyMessage(jws, identity.History, sg) == nil
+
+	return m
+}
+
+// verifyMessage verifies a message was signed by a key that was valid
+// on the sender's signature graph at the time the message was issued
+func verifyMessage(jws *jose.JSONWebSignature, history []json.RawMessage, sg *siggraph.SignatureGraph) error {
+	keys, err := keyHistory(history, sg)
+	if err != nil {
+		return err
+	}
+
+	k := findKey(keys, jws.Signatures[0].Header.KeyID)
+	if k == nil {
+		return errors.New("the message was not signed with a key from the sender's signature graph")
+	}
+
+	payload, err := jws.Verify(k.PublicKey)
+	if err != nil {
+		return err
+	}
+
+	signedAt, err := payloadTime(payload)
+	if err != nil {
+		return err
+	}
+
+	if !k.validAt(signedAt) {
+		return errors.New("the key was not valid when the message was issued")
+	}
+
+	return nil
+}
+
+// waitForMessage waits for a message that matches, until the context is
+// cancelled or the timeout elapses
+func waitForMessage(ctx context.Context, received chan *receivedMessage, timeout time.Duration, match func(m *receivedMessage) bool) (*receivedMessage, error) {
+	expired := time.NewTimer(timeout)
+	defer expired.Stop()
+
+	for {
+		select {
+		case m := <-received:
+			if match(m) {
+				return m, nil
+			}
+		case <-expired.C:
+			return nil, fmt.Errorf("no message received after %s", timeout)
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// conversation matches messages of a type that are part of a conversation
+func conversation(typ, cid string) func(m *receivedMessage) bool {
+	return func(m *receivedMessage) bool {
+		return m.Type == typ && m.Payload["cid"] == cid
+	}
+}
+
+// messagingURL returns the url of self messaging, either as specified by the
+// '--messaging-url' flag, or the messaging url of the environment being targeted
+func messagingURL() (string, error) {
+	if v.GetString("self_messaging_url") != "" {
+		return v.GetString("self_messaging_url"), nil
+	}
+
+	env, err := currentEnvironment()
+	if err != nil {
+		return "", err
+	}
+
+	if env.MessagingURL == "" {
+		return "", errors.New("the environment does not have a messaging url, specify one with '--messaging-url' or the environment's messaging_url")
+	}
+
+	return env.MessagingURL, nil
+}
@@ -0,0 +1,95 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var messageListenCommand = &cobra.Command{
+	Use:   "listen",
+	Short: "listens for messages sent to one of an identities devices",
+	Long:  "listens for messages sent to the device that the secret key belongs to, printing each message as a line of json. Messages are verified against the sender's signature graph",
+	Example: `  self-cli message listen --app-id [appID] --secret-key MY-SECRET-DEVICE-KEY
+  self-cli message listen --type chat.message --type cli.ping --count 1 --wait 30s --app-id [appID] --secret-key MY-SECRET-DEVICE-KEY`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if appID == "" {
+			check(errors.New("you must specify the identity that the device belongs to [--app-id]"))
+		}
+
+		if messageCount < 0 {
+			check(errors.New("count must not be negative"))
+		}
+
+		sk := mustSecretKey(secretKey, keyTypeSecret, "secret key")
+
+		ctx := cmd.Context()
+
+		done := make(chan error)
+
+		// connect the device to messaging
+		go log("connecting to messaging", done)
+
+		d, err := connectDevice(ctx, appID, sk)
+		done <- err
+
+		if err != nil {
+			exit(1)
+		}
+
+		received := make(chan *receivedMessage, 16)
+		d.subscribe(messageTypes, received)
+
+		progressf("listening for messages to %s\n", d.address())
+
+		var expired <-chan time.Time
+
+		if messageWait > 0 {
+			timer := time.NewTimer(messageWait)
+			defer timer.Stop()
+			expired = timer.C
+		}
+
+		var count int
+
+		for messageCount == 0 || count < messageCount {
+			select {
+			case m := <-received:
+				data, err := json.Marshal(m)
+				check(err)
+
+				fmt.Println(string(data))
+				count++
+			case <-expired:
+				d.client.Close()
+
+				if messageCount > 0 {
+					errorf("received %d of %d messages after %s\n", count, messageCount, messageWait)
+					exit(1)
+				}
+
+				return
+			case <-ctx.Done():
+				d.client.Close()
+				exit(1)
+			}
+		}
+
+		d.client.Close()
+	},
+}
+
+func init() {
+	messageCommand.AddCommand(messageListenCommand)
+	messageListenCommand.Flags().StringVarP(&secretKey, "secret-key", "s", "", "Secret key of the device to listen as")
+	messageListenCommand.Flags().StringVarP(&appID, "app-id", "a", "", "Identity that the device belongs to")
+	messageListenCommand.Flags().StringSliceVarP(&messageTypes, "type", "t", []string{messageTypeChat}, "Types of message to listen for")
+	messageListenCommand.Flags().IntVarP(&messageCount, "count", "n", 0, "Number of messages to receive before exiting (default is to listen until interrupted)")
+	messageListenCommand.Flags().DurationVarP(&messageWait, "wait", "w", 0, "How long to listen for, exiting with an error if fewer than count messages were received (default is forever)")
+	addMessagingFlags(messageListenCommand)
+}
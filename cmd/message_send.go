@@ -0,0 +1,78 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+var messageSendCommand = &cobra.Command{
+	Use:   "send [recipient] [body]",
+	Short: "sends a message from one of an identities devices",
+	Long:  "sends a signed message from the device that the secret key belongs to. The recipient is either an identity, to send to all of its advertised devices, or a specific device '[selfID]:[deviceID]'",
+	Example: `  self-cli message send [selfID] "hello" --app-id [appID] --secret-key MY-SECRET-DEVICE-KEY
+  self-cli message send [selfID]:[deviceID] "hello" --app-id [appID] --secret-key MY-SECRET-DEVICE-KEY`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) < 2 {
+			check(errors.New("you must specify a recipient and a message body [recipient] [body]"))
+		}
+
+		if appID == "" {
+			check(errors.New("you must specify the identity that the device belongs to [--app-id]"))
+		}
+
+		sk := mustSecretKey(secretKey, keyTypeSecret, "secret key")
+
+		recipients, err := sendMessage(cmd.Context(), sk, args[0], args[1])
+		if err != nil {
+			exit(1)
+		}
+
+		progressf("\n")
+
+		for _, r := range recipients {
+			fmt.Println(r)
+		}
+	},
+}
+
+func init() {
+	messageCommand.AddCommand(messageSendCommand)
+	messageSendCommand.Flags().StringVarP(&secretKey, "secret-key", "s", "", "Secret key of the device to send from")
+	messageSendCommand.Flags().StringVarP(&appID, "app-id", "a", "", "Identity that the device belongs to")
+	messageSendCommand.Flags().StringVarP(&messageType, "type", "t", messageTypeChat, "Type of the message")
+	addMessagingFlags(messageSendCommand)
+}
+
+// sendMessage connects the device to messaging and sends a message, closing
+// the connection before returning the devices the message was sent to
+func sendMessage(ctx context.Context, sk *key, recipient, body string) ([]string, error) {
+	done := make(chan error)
+
+	// connect the device to messaging
+	go log("connecting to messaging", done)
+
+	d, err := connectDevice(ctx, appID, sk)
+	done <- err
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer d.client.Close()
+
+	// send the message
+	go log("sending message", done)
+
+	recipients, err := d.send(recipient, messageType, map[string]interface{}{
+		"msg": body,
+	})
+
+	done <- err
+
+	return recipients, err
+}
@@ -0,0 +1,27 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+)
+
+var messageServeCommand = &cobra.Command{
+	Use:   "serve",
+	Short: "runs a local stand-in messaging server for testing",
+	Long:  "runs a local stand-in for self messaging, which relays messages between devices without encrypting them, so that messaging can be tested without the libraries the sdk requires. Use it by setting the messaging url to the server's 'http://' url",
+	Example: `  self-cli message serve --listen localhost:8086
+  self-cli message listen --messaging-url http://localhost:8086 --app-id [appID] --secret-key MY-SECRET-DEVICE-KEY`,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := cmd.Context()
+
+		progressf("serving stand-in messaging on %s\n", messageAddr)
+
+		check(serve(ctx, messageAddr, newStandInServer(ctx)))
+	},
+}
+
+func init() {
+	messageCommand.AddCommand(messageServeCommand)
+	messageServeCommand.Flags().StringVarP(&messageAddr, "listen", "l", "localhost:8086", "Address to serve stand-in messaging on")
+}
@@ -0,0 +1,421 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/joinself/self-go-sdk/pkg/siggraph"
+	"github.com/square/go-jose"
+)
+
+// testMessagingClient records the messages sent by a device, and
+// delivers messages to its subscribers when they are received
+type testMessagingClient struct {
+	mu   sync.Mutex
+	sent []standInMessage
+	subs map[string]func(sender string, payload []byte)
+}
+
+func (c *testMessagingClient) Send(recipients []string, mtype string, plaintext []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.sent = append(c.sent, standInMessage{Recipients: recipients, Type: mtype, Payload: plaintext})
+
+	return nil
+}
+
+func (c *testMessagingClient) Subscribe(msgType string, sub func(sender string, payload []byte)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.subs == nil {
+		c.subs = make(map[string]func(sender string, payload []byte))
+	}
+
+	c.subs[msgType] = sub
+}
+
+func (c *testMessagingClient) Close() error {
+	return nil
+}
+
+// receive delivers a message to the subscriber of its type
+func (c *testMessagingClient) receive(sender, mtype string, payload []byte) {
+	c.mu.Lock()
+	sub := c.subs[mtype]
+	c.mu.Unlock()
+
+	if sub != nil {
+		sub(sender, payload)
+	}
+}
+
+// testDevice connects one of a test identity's devices to a test messaging client
+func testDevice(t *testing.T, ti *testIdentity, did, kid string) (*messagingDevice, *testMessagingClient) {
+	t.Helper()
+
+	client := &testMessagingClient{}
+
+	return &messagingDevice{
+		ctx:    context.Background(),
+		client: client,
+		api:    testRest(t, ti),
+		selfID: ti.selfID,
+		did:    did,
+		sk:     ti.keys[kid],
+	}, client
+}
+
+func TestMessagingDeviceSend(t *testing.T) {
+	ti := newTestIdentity(t, "app", time.Now().Add(-time.Hour), "1", "2", "3")
+
+	d, client := testDevice(t, ti, "1", "1")
+
+	tests := []struct {
+		name       string
+		recipient  string
+		recipients []string
+		err        bool
+	}{
+		{"identity", "app", []string{"app:2", "app:3"}, false},
+		{"device", "app:3", []string{"app:3"}, false},
+		{"unknown identity", "unknown", nil, true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			client.sent = nil
+
+			recipients, err := d.send(tc.recipient, messageTypePing, map[string]interface{}{"cid": "conversation"})
+			if tc.err {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			sort.Strings(recipients)
+
+			if strings.Join(recipients, ",") != strings.Join(tc.recipients, ",") {
+				t.Fatalf("expected recipients %v, got %v", tc.recipients, recipients)
+			}
+
+			if len(client.sent) != 1 || client.sent[0].Type != messageTypePing {
+				t.Fatalf("expected one %s message to be sent, got %v", messageTypePing, client.sent)
+			}
+
+			jws, err := jose.ParseSigned(string(client.sent[0].Payload))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			payload, err := jws.Verify(ti.keys["1"].publicKey())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var m map[string]interface{}
+
+			err = json.Unmarshal(payload, &m)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if m["typ"] != messageTypePing || m["iss"] != "app" || m["device_id"] != "1" || m["cid"] != "conversation" {
+				t.Fatalf("unexpected message payload %s", payload)
+			}
+		})
+	}
+}
+
+func TestMessagingDeviceSendNoDevices(t *testing.T) {
+	ti := newTestIdentity(t, "app", time.Now().Add(-time.Hour), "1")
+
+	d, _ := testDevice(t, ti, "1", "1")
+
+	// the only advertised device is the sender
+	_, err := d.send("app", messageTypeChat, nil)
+	if err == nil {
+		t.Fatal("expected an error when there are no devices to send to")
+	}
+}
+
+func TestVerifyMessage(t *testing.T) {
+	now := time.Now()
+
+	ti := newTestIdentity(t, "app", now.Add(-time.Hour), "1", "2")
+
+	// revoke device 2's key half an hour ago
+	ti.add(t, ti.keys["1"], now.Add(-30*time.Minute), siggraph.Action{
+		KID:           "2",
+		DID:           "2",
+		Type:          siggraph.TypeDeviceKey,
+		Action:        siggraph.ActionKeyRevoke,
+		EffectiveFrom: now.Add(-30 * time.Minute).Unix(),
+	})
+
+	sign := func(sk *key, issued time.Time) *jose.JSONWebSignature {
+		payload, err := json.Marshal(map[string]interface{}{
+			"typ": messageTypeChat,
+			"iat": issued.UTC().Format(time.RFC3339),
+		})
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		jws, err := signJWS(sk, payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// messages are received serialized
+		parsed, err := jose.ParseSigned(jws.FullSerialize())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return parsed
+	}
+
+	tests := []struct {
+		name string
+		jws  *jose.JSONWebSignature
+		err  bool
+	}{
+		{"valid key", sign(ti.keys["1"], now), false},
+		{"before revocation", sign(ti.keys["2"], now.Add(-45*time.Minute)), false},
+		{"after revocation", sign(ti.keys["2"], now), true},
+		{"before key was added", sign(ti.keys["1"], now.Add(-2*time.Hour)), true},
+		{"unknown key", sign(generateKey(keyTypeSecret, "9"), now), true},
+		{"key from another identity", sign(newTestIdentity(t, "other", now.Add(-time.Hour), "1").keys["1"], now), true},
+	}
+
+	sg := ti.graph(t)
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := verifyMessage(tc.jws, ti.history, sg)
+			if tc.err && err == nil {
+				t.Fatal("expected an error")
+			}
+
+			if !tc.err && err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestPingPong(t *testing.T) {
+	ti := newTestIdentity(t, "app", time.Now().Add(-time.Hour), "1", "2")
+
+	sender, sc := testDevice(t, ti, "1", "1")
+	recipient, rc := testDevice(t, ti, "2", "2")
+
+	pings := make(chan *receivedMessage, 1)
+	pongs := make(chan *receivedMessage, 1)
+
+	recipient.subscribe([]string{messageTypePing}, pings)
+	sender.subscribe([]string{messageTypePong}, pongs)
+
+	deliver := func(from *messagingDevice, client *testMessagingClient, to *testMessagingClient) {
+		for _, m := range client.sent {
+			go to.receive(from.address(), m.Type, m.Payload)
+		}
+
+		client.sent = nil
+	}
+
+	ctx := context.Background()
+
+	// a ping from another conversation is ignored
+	_, err := sender.send(recipient.address(), messageTypePing, map[string]interface{}{"cid": "other"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = sender.send(recipient.address(), messageTypePing, map[string]interface{}{"cid": "ping"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deliver(sender, sc, rc)
+
+	m, err := waitForMessage(ctx, pings, time.Second, conversation(messageTypePing, "ping"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !m.Verified || m.Sender != sender.address() {
+		t.Fatalf("expected a verified ping from %s, got %+v", sender.address(), m)
+	}
+
+	_, err = recipient.send(sender.address(), messageTypePong, map[string]interface{}{"cid": "ping"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deliver(recipient, rc, sc)
+
+	m, err = waitForMessage(ctx, pongs, time.Second, conversation(messageTypePong, "ping"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !m.Verified || m.Sender != recipient.address() {
+		t.Fatalf("expected a verified pong from %s, got %+v", recipient.address(), m)
+	}
+}
+
+func TestConversation(t *testing.T) {
+	match := conversation(messageTypePong, "abc")
+
+	tests := []struct {
+		name    string
+		message *receivedMessage
+		matches bool
+	}{
+		{"matching", &receivedMessage{Type: messageTypePong, Payload: map[string]interface{}{"cid": "abc"}}, true},
+		{"other conversation", &receivedMessage{Type: messageTypePong, Payload: map[string]interface{}{"cid": "def"}}, false},
+		{"other type", &receivedMessage{Type: messageTypePing, Payload: map[string]interface{}{"cid": "abc"}}, false},
+		{"no payload", &receivedMessage{Type: messageTypePong}, false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if match(tc.message) != tc.matches {
+				t.Fatalf("expected match to be %t", tc.matches)
+			}
+		})
+	}
+}
+
+func TestWaitForMessage(t *testing.T) {
+	match := conversation(messageTypePong, "abc")
+
+	received := make(chan *receivedMessage, 2)
+	received <- &receivedMessage{Type: messageTypePong, Payload: map[string]interface{}{"cid": "def"}}
+	received <- &receivedMessage{Type: messageTypePong, Payload: map[string]interface{}{"cid": "abc"}}
+
+	m, err := waitForMessage(context.Background(), received, time.Second, match)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if m.Payload["cid"] != "abc" {
+		t.Fatalf("expected the matching message, got %+v", m)
+	}
+
+	_, err = waitForMessage(context.Background(), received, 10*time.Millisecond, match)
+	if err == nil {
+		t.Fatal("expected an error when no message is received")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err = waitForMessage(ctx, received, time.Minute, match)
+	if err != context.Canceled {
+		t.Fatalf("expected the wait to be cancelled, got %v", err)
+	}
+}
+
+func TestSubscribeCancelled(t *testing.T) {
+	ti := newTestIdentity(t, "app", time.Now().Add(-time.Hour), "1")
+
+	d, client := testDevice(t, ti, "1", "1")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	d.ctx = ctx
+
+	// nothing receives from the channel
+	d.subscribe([]string{messageTypeChat}, make(chan *receivedMessage))
+
+	delivered := make(chan struct{})
+
+	go func() {
+		client.receive("app:2", messageTypeChat, []byte("{}"))
+		close(delivered)
+	}()
+
+	cancel()
+
+	select {
+	case <-delivered:
+	case <-time.After(time.Second):
+		t.Fatal("the client was blocked delivering a message after the context was cancelled")
+	}
+}
+
+func TestStandInMessaging(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	srv := httptest.NewServer(newStandInServer(ctx))
+	defer srv.Close()
+
+	if !isStandInURL(srv.URL) {
+		t.Fatalf("expected %s to be a stand-in messaging url", srv.URL)
+	}
+
+	alice := newStandInClient(messagingConfig{SelfID: "app", DeviceID: "1", MessagingURL: srv.URL})
+	bob := newStandInClient(messagingConfig{SelfID: "app", DeviceID: "2", MessagingURL: srv.URL})
+
+	type delivery struct {
+		sender  string
+		payload string
+	}
+
+	received := make(chan delivery, 2)
+
+	bob.Subscribe(messageTypeChat, func(sender string, payload []byte) {
+		received <- delivery{sender, string(payload)}
+	})
+
+	err := alice.Send([]string{"app:2"}, messageTypePing, []byte("ignored"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = alice.Send([]string{"app:2", "app:3"}, messageTypeChat, []byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case d := <-received:
+		if d.sender != "app:1" || d.payload != "hello" {
+			t.Fatalf("unexpected message %+v", d)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("message was not received")
+	}
+
+	closed := make(chan struct{})
+
+	go func() {
+		alice.Close()
+		bob.Close()
+		close(closed)
+	}()
+
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("closing the clients blocked")
+	}
+}
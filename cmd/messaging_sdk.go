@@ -0,0 +1,98 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+//go:build messaging
+
+package cmd
+
+import (
+	"time"
+
+	"github.com/joinself/self-go-sdk/pkg/crypto"
+	"github.com/joinself/self-go-sdk/pkg/messaging"
+	"github.com/joinself/self-go-sdk/pkg/pki"
+	"github.com/joinself/self-go-sdk/pkg/transport"
+)
+
+// sdkMessagingClient the sdk's messaging client, closing its websocket when it is closed
+type sdkMessagingClient struct {
+	*messaging.Client
+	ws *transport.Websocket
+}
+
+// newMessagingClient connects a device to self messaging using the sdk's messaging
+// client, with messages end to end encrypted by the sdk's crypto client
+func newMessagingClient(cfg messagingConfig) (messagingClient, error) {
+	ws, err := transport.NewWebsocket(transport.WebsocketConfig{
+		MessagingURL: cfg.MessagingURL,
+		StorageDir:   cfg.OffsetDir,
+		SelfID:       cfg.SelfID,
+		DeviceID:     cfg.DeviceID,
+		KeyID:        cfg.Key.kid,
+		PrivateKey:   cfg.Key.privateKey(),
+		TCPDeadline:  90 * time.Second,
+		InboxSize:    256,
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	storage, err := crypto.NewFileStorage(crypto.StorageConfig{
+		StorageDir: cfg.CryptoDir,
+	})
+
+	if err != nil {
+		ws.Close()
+		return nil, err
+	}
+
+	keys, err := pki.New(pki.Config{
+		SelfID:     cfg.SelfID,
+		PrivateKey: cfg.Key.privateKey(),
+		APIURL:     apiURL(),
+		Transport:  cfg.API,
+	})
+
+	if err != nil {
+		ws.Close()
+		return nil, err
+	}
+
+	cr, err := crypto.New(crypto.Config{
+		SelfID:     cfg.SelfID,
+		DeviceID:   cfg.DeviceID,
+		PrivateKey: cfg.Key.privateKey(),
+		StorageKey: cfg.StorageKey,
+		Storage:    storage,
+		PKI:        keys,
+	})
+
+	if err != nil {
+		ws.Close()
+		return nil, err
+	}
+
+	client, err := messaging.New(messaging.Config{
+		SelfID:     cfg.SelfID,
+		DeviceID:   cfg.DeviceID,
+		PrivateKey: cfg.Key.privateKey(),
+		Crypto:     cr,
+		Transport:  ws,
+	})
+
+	if err != nil {
+		ws.Close()
+		return nil, err
+	}
+
+	return &sdkMessagingClient{Client: client, ws: ws}, nil
+}
+
+// Close closes the websocket, so that the client's reader is not
+// blocked waiting for a message, before closing the client
+func (c *sdkMessagingClient) Close() error {
+	err := c.ws.Close()
+	c.Client.Close()
+
+	return err
+}
@@ -0,0 +1,280 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// standInPollWait how long a stand-in client waits for messages on each poll
+const standInPollWait = 25 * time.Second
+
+// standInMessage a message relayed by the stand-in messaging server
+type standInMessage struct {
+	Sender     string   `json:"sender"`
+	Recipients []string `json:"recipients,omitempty"`
+	Type       string   `json:"type"`
+	Payload    []byte   `json:"payload"`
+}
+
+// standInServer a local stand-in for self messaging, for testing messaging without
+// the libraries the sdk requires. Messages are relayed as they are sent, without
+// being encrypted, and are queued for each recipient until they are received
+type standInServer struct {
+	ctx     context.Context
+	mu      sync.Mutex
+	queues  map[string][]standInMessage
+	waiters map[string]chan struct{}
+}
+
+// standInClient a client for the stand-in messaging server, used in place of the
+// sdk's messaging client when the messaging url is 'http://' or 'https://'
+type standInClient struct {
+	url      string
+	address  string
+	client   *http.Client
+	ctx      context.Context
+	cancel   context.CancelFunc
+	mu       sync.Mutex
+	subs     map[string]func(sender string, payload []byte)
+	polling  sync.Once
+	finished sync.WaitGroup
+}
+
+// isStandInURL returns true if a messaging url refers to a stand-in messaging server
+func isStandInURL(messagingURL string) bool {
+	return strings.HasPrefix(messagingURL, "http://") || strings.HasPrefix(messagingURL, "https://")
+}
+
+// newStandInServer creates a stand-in messaging server, which stops waiting
+// for messages when the context is cancelled
+func newStandInServer(ctx context.Context) *standInServer {
+	return &standInServer{
+		ctx:     ctx,
+		queues:  make(map[string][]standInMessage),
+		waiters: make(map[string]chan struct{}),
+	}
+}
+
+// ServeHTTP relays messages that are posted, and returns any queued messages
+// for an address, waiting for up to 'wait' if there are none
+func (s *standInServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/v1/messages" {
+		http.NotFound(w, r)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPost:
+		var m standInMessage
+
+		err := json.NewDecoder(r.Body).Decode(&m)
+		if err != nil || m.Sender == "" || len(m.Recipients) < 1 {
+			http.Error(w, "messages must specify a sender and recipients", http.StatusBadRequest)
+			return
+		}
+
+		s.push(m)
+
+		w.WriteHeader(http.StatusAccepted)
+	case http.MethodGet:
+		address := r.URL.Query().Get("address")
+		if address == "" {
+			http.Error(w, "an address must be specified", http.StatusBadRequest)
+			return
+		}
+
+		wait, _ := time.ParseDuration(r.URL.Query().Get("wait"))
+
+		messages := s.receive(r.Context(), address, wait)
+		if messages == nil {
+			messages = []standInMessage{}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(messages)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// push queues a message for each of its recipients
+func (s *standInServer) push(m standInMessage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	recipients := m.Recipients
+	m.Recipients = nil
+
+	for _, r := range recipients {
+		s.queues[r] = append(s.queues[r], m)
+
+		if w, ok := s.waiters[r]; ok {
+			close(w)
+			delete(s.waiters, r)
+		}
+	}
+}
+
+// receive returns the queued messages for an address, waiting for messages
+// to be sent if there are none
+func (s *standInServer) receive(ctx context.Context, address string, wait time.Duration) []standInMessage {
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	for {
+		s.mu.Lock()
+
+		messages := s.queues[address]
+		if len(messages) > 0 {
+			delete(s.queues, address)
+			s.mu.Unlock()
+			return messages
+		}
+
+		w, ok := s.waiters[address]
+		if !ok {
+			w = make(chan struct{})
+			s.waiters[address] = w
+		}
+
+		s.mu.Unlock()
+
+		select {
+		case <-w:
+		case <-timer.C:
+			return nil
+		case <-ctx.Done():
+			return nil
+		case <-s.ctx.Done():
+			return nil
+		}
+	}
+}
+
+// newStandInClient connects a device to a stand-in messaging server
+func newStandInClient(cfg messagingConfig) messagingClient {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &standInClient{
+		url:     strings.TrimSuffix(cfg.MessagingURL, "/") + "/v1/messages",
+		address: cfg.SelfID + ":" + cfg.DeviceID,
+		client:  &http.Client{Timeout: standInPollWait + 10*time.Second},
+		ctx:     ctx,
+		cancel:  cancel,
+		subs:    make(map[string]func(sender string, payload []byte)),
+	}
+}
+
+// Send sends a message to the recipients
+func (c *standInClient) Send(recipients []string, mtype string, plaintext []byte) error {
+	data, err := json.Marshal(standInMessage{
+		Sender:     c.address,
+		Recipients: recipients,
+		Type:       mtype,
+		Payload:    plaintext,
+	})
+
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(c.ctx, http.MethodPost, c.url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusAccepted {
+		return fmt.Errorf("stand-in messaging server returned %s", resp.Status)
+	}
+
+	return nil
+}
+
+// Subscribe calls sub for each message of the given type that is received.
+// Messages are polled for once there is a subscription
+func (c *standInClient) Subscribe(msgType string, sub func(sender string, payload []byte)) {
+	c.mu.Lock()
+	c.subs[msgType] = sub
+	c.mu.Unlock()
+
+	c.polling.Do(func() {
+		c.finished.Add(1)
+		go c.poll()
+	})
+}
+
+// Close stops polling for messages
+func (c *standInClient) Close() error {
+	c.cancel()
+	c.finished.Wait()
+
+	return nil
+}
+
+// poll receives messages until the client is closed, retrying after any error
+func (c *standInClient) poll() {
+	defer c.finished.Done()
+
+	u := c.url + "?address=" + url.QueryEscape(c.address) + "&wait=" + standInPollWait.String()
+
+	for c.ctx.Err() == nil {
+		messages, err := c.receive(u)
+		if err != nil {
+			verbosef("failed to receive messages: %s\n", err.Error())
+			sleep(c.ctx, time.Second)
+			continue
+		}
+
+		for _, m := range messages {
+			c.mu.Lock()
+			sub := c.subs[m.Type]
+			c.mu.Unlock()
+
+			if sub != nil {
+				sub(m.Sender, m.Payload)
+			}
+		}
+	}
+}
+
+func (c *standInClient) receive(u string) ([]standInMessage, error) {
+	req, err := http.NewRequestWithContext(c.ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("stand-in messaging server returned %s", resp.Status)
+	}
+
+	var messages []standInMessage
+
+	err = json.NewDecoder(resp.Body).Decode(&messages)
+
+	return messages, err
+}
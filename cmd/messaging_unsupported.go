@@ -0,0 +1,13 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+//go:build !messaging
+
+package cmd
+
+import "errors"
+
+// newMessagingClient is not supported, as the sdk's messaging client
+// requires the libself_olm and libsodium libraries to encrypt messages
+func newMessagingClient(cfg messagingConfig) (messagingClient, error) {
+	return nil, errors.New("self-cli was built without messaging support, which requires libself_olm and libsodium. Rebuild it with 'go build -tags messaging', or use a stand-in messaging server with 'message serve'")
+}
@@ -0,0 +1,78 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+)
+
+const (
+	colorRed     = "1;31"
+	colorGreen   = "1;32"
+	colorYellow  = "1;33"
+	colorBlue    = "1;34"
+	colorSpinner = "34"
+)
+
+var (
+	quiet   bool
+	verbose bool
+)
+
+func init() {
+	rootCmd.PersistentFlags().BoolVar(&quiet, "quiet", false, "Only output the result of the command and any errors")
+	rootCmd.PersistentFlags().BoolVar(&verbose, "verbose", false, "Output details of each step, such as how long it took and any requests that were retried")
+}
+
+// isTerminal returns true if the file is a terminal
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+
+	return fi.Mode()&os.ModeCharDevice != 0
+}
+
+// colorEnabled returns true if ansi colours should be written to the file. Colours
+// are disabled if the file is not a terminal, or if 'NO_COLOR' is set
+func colorEnabled(f *os.File) bool {
+	if os.Getenv("NO_COLOR") != "" || os.Getenv("TERM") == "dumb" {
+		return false
+	}
+
+	return isTerminal(f)
+}
+
+// paint colours text with an ansi colour, if colours are enabled for the file
+func paint(f *os.File, color, text string) string {
+	if !colorEnabled(f) {
+		return text
+	}
+
+	return "\033[" + color + "m" + text + "\033[0m"
+}
+
+// progressf writes the progress of a command to stderr, unless '--quiet' is set
+func progressf(format string, a ...interface{}) {
+	if quiet {
+		return
+	}
+
+	fmt.Fprintf(os.Stderr, format, a...)
+}
+
+// verbosef writes details of a command to stderr if '--verbose' is set
+func verbosef(format string, a ...interface{}) {
+	if !verbose || quiet {
+		return
+	}
+
+	fmt.Fprintf(os.Stderr, format, a...)
+}
+
+// errorf writes an error to stderr, regardless of '--quiet'
+func errorf(format string, a ...interface{}) {
+	fmt.Fprintf(os.Stderr, format, a...)
+}
@@ -0,0 +1,162 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package cmd
+
+import (
+	"errors"
+	"os"
+	"regexp"
+	"testing"
+)
+
+// setOutputFlags sets '--quiet' and '--verbose' for the duration of a test
+func setOutputFlags(t *testing.T, q, vb bool) {
+	t.Helper()
+
+	previousQuiet, previousVerbose := quiet, verbose
+	t.Cleanup(func() { quiet, verbose = previousQuiet, previousVerbose })
+
+	quiet, verbose = q, vb
+}
+
+func TestPaint(t *testing.T) {
+	tests := []struct {
+		name    string
+		noColor string
+		term    string
+	}{
+		{"not a terminal", "", "xterm"},
+		{"no color", "1", "xterm"},
+		{"dumb terminal", "", "dumb"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("NO_COLOR", tc.noColor)
+			t.Setenv("TERM", tc.term)
+
+			out := captureOutput(t, &os.Stderr, func() {
+				if colorEnabled(os.Stderr) {
+					t.Error("expected colours to be disabled")
+				}
+
+				os.Stderr.WriteString(paint(os.Stderr, colorGreen, "✓"))
+			})
+
+			if out != "✓" {
+				t.Fatalf("expected text without ansi colours, got %q", out)
+			}
+		})
+	}
+}
+
+func TestProgressOutput(t *testing.T) {
+	tests := []struct {
+		name     string
+		quiet    bool
+		verbose  bool
+		expected string
+	}{
+		{"default", false, false, "progress\nerror\n"},
+		{"quiet", true, false, "error\n"},
+		{"verbose", false, true, "progress\ndetails\nerror\n"},
+		{"quiet and verbose", true, true, "error\n"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			setOutputFlags(t, tc.quiet, tc.verbose)
+
+			var stderr string
+
+			stdout := captureOutput(t, &os.Stdout, func() {
+				stderr = captureOutput(t, &os.Stderr, func() {
+					progressf("%s\n", "progress")
+					verbosef("%s\n", "details")
+					errorf("%s\n", "error")
+				})
+			})
+
+			if stdout != "" {
+				t.Fatalf("expected no output to stdout, got %q", stdout)
+			}
+
+			if stderr != tc.expected {
+				t.Fatalf("expected %q to stderr, got %q", tc.expected, stderr)
+			}
+		})
+	}
+}
+
+func TestLog(t *testing.T) {
+	t.Setenv("NO_COLOR", "1")
+
+	tests := []struct {
+		name     string
+		quiet    bool
+		verbose  bool
+		err      error
+		expected string
+	}{
+		{
+			name:     "completed",
+			expected: `^  ✓   adding device\n$`,
+		},
+		{
+			name:     "failed",
+			err:      errors.New("device already exists"),
+			expected: `^  ✘   adding device\n\nerrored with: \n  device already exists\n$`,
+		},
+		{
+			name:     "quiet",
+			quiet:    true,
+			expected: `^$`,
+		},
+		{
+			name:     "quiet failed",
+			quiet:    true,
+			err:      errors.New("device already exists"),
+			expected: `^  ✘   adding device\n\nerrored with: \n  device already exists\n$`,
+		},
+		{
+			name:     "verbose",
+			verbose:  true,
+			expected: `^  -   adding device\n  ✓   adding device \([0-9.]+m?s\)\n$`,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			setOutputFlags(t, tc.quiet, tc.verbose)
+
+			previous := steps
+			defer func() { steps = previous }()
+
+			steps = nil
+
+			var stderr string
+
+			stdout := captureOutput(t, &os.Stdout, func() {
+				stderr = captureOutput(t, &os.Stderr, func() {
+					done := make(chan error, 1)
+					done <- tc.err
+
+					// without a terminal, no spinner frames are written
+					log("adding device", done)
+				})
+			})
+
+			if stdout != "" {
+				t.Fatalf("expected no output to stdout, got %q", stdout)
+			}
+
+			if !regexp.MustCompile(tc.expected).MatchString(stderr) {
+				t.Fatalf("expected stderr to match %q, got %q", tc.expected, stderr)
+			}
+
+			if len(steps) != 1 || steps[0].done != (tc.err == nil) {
+				t.Fatal("expected the step to be recorded")
+			}
+		})
+	}
+}
@@ -3,10 +3,12 @@
 package cmd
 
 import (
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"os"
 	"strings"
@@ -20,7 +22,6 @@ import (
 	"github.com/spf13/viper"
 	"github.com/square/go-jose"
 	"github.com/tj/go-spin"
-	"golang.org/x/crypto/ed25519"
 )
 
 var (
@@ -31,6 +32,22 @@ var (
 	devicePublicKey   string
 	recoveryPublicKey string
 	effectiveFrom     int
+	keyType           string
+	keyID             string
+	keyFormat         string
+	publicOnly        bool
+	includeRevoked    bool
+	appID             string
+	listenAddr        string
+	metricsAddr       string
+	historyFile       string
+	atTime            string
+	selfID            string
+	atSequence        int
+	pollInterval      time.Duration
+	inventoryFile     string
+	configFile        string
+	concurrency       int
 )
 
 // Identity represents an identity
@@ -49,14 +66,21 @@ var rootCmd = &cobra.Command{
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
-	if err := rootCmd.Execute(); err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+	if err := rootCmd.ExecuteContext(signalContext()); err != nil {
+		errorf("%s\n", err)
+		exit(1)
 	}
+
+	exit(0)
 }
 
 func init() {
 	cobra.OnInitialize(initConfig)
+
+	rootCmd.PersistentFlags().StringVar(&configFile, "config", "", "Config file (default is $HOME/.self-cli.yaml)")
+	rootCmd.PersistentFlags().String("env", "", "Environment to target, either a builtin environment or one from the config's environment registry [SELF_ENV]")
+	rootCmd.PersistentFlags().String("api-url", "", "URL of the api, overrides the environment's api url [SELF_API_URL]")
+	rootCmd.PersistentFlags().String("messaging-url", "", "URL of self messaging, overrides the environment's messaging url [SELF_MESSAGING_URL]")
 }
 
 // initConfig reads in config file and ENV variables if set.
@@ -65,114 +89,216 @@ func initConfig() {
 
 	v.SetDefault("self_env", "production")
 	v.AutomaticEnv()
-}
 
-func rest(selfID, sk string) *transport.Rest {
-	cfg := transport.RestConfig{
-		APIURL:     apiURL(),
-		Client:     &http.Client{},
-		SelfID:     selfID,
-		KeyID:      parseKeyID(sk),
-		PrivateKey: parseSecretKey(sk),
-	}
+	check(v.BindPFlag("self_env", rootCmd.PersistentFlags().Lookup("env")))
+	check(v.BindPFlag("self_api_url", rootCmd.PersistentFlags().Lookup("api-url")))
+	check(v.BindPFlag("self_messaging_url", rootCmd.PersistentFlags().Lookup("messaging-url")))
 
-	client, err := transport.NewRest(cfg)
-	check(err)
+	if configFile != "" {
+		v.SetConfigFile(configFile)
+	} else {
+		home, err := os.UserHomeDir()
+		if err == nil {
+			v.AddConfigPath(home)
+		}
 
-	return client
+		v.SetConfigName(".self-cli")
+	}
+
+	err := v.ReadInConfig()
+	if err != nil && (configFile != "" || !errors.As(err, &viper.ConfigFileNotFoundError{})) {
+		check(fmt.Errorf("failed to read config file: %w", err))
+	}
 }
 
-func pk(rest *transport.Rest) *pki.Client {
-	client, err := pki.New(pki.Config{Transport: rest})
+// rest creates a client for the api, authenticated as the given identity. All
+// requests made by the client are made with the given context
+func rest(ctx context.Context, selfID string, sk *key) *transport.Rest {
+	client, err := newRest(ctx, selfID, sk)
 	check(err)
 
 	return client
 }
 
-func parseKeyID(sk string) string {
-	return strings.Split(sk, ":")[0]
+// newRest builds a client for the api, authenticated with a secret key,
+// returning any error with the api's configuration
+func newRest(ctx context.Context, selfID string, sk *key) (*transport.Rest, error) {
+	hc, err := newAPIClient()
+	if err != nil {
+		return nil, err
+	}
+
+	return newRestWithTransport(selfID, sk, &contextTransport{ctx: ctx, next: hc.Transport})
 }
 
-func parseSecretKey(sk string) ed25519.PrivateKey {
-	kp := strings.Split(sk, ":")
-	if len(kp) < 2 {
-		check(errors.New("provided secret key is not valid"))
+// newRestWithTransport creates an api client for an identity that sends its requests with a transport
+func newRestWithTransport(selfID string, sk *key, rt http.RoundTripper) (*transport.Rest, error) {
+	api, err := resolveAPIURL()
+	if err != nil {
+		return nil, err
 	}
 
-	seed, err := base64.RawStdEncoding.DecodeString(kp[1])
+	cfg := transport.RestConfig{
+		APIURL:     api,
+		Client:     &http.Client{Transport: rt},
+		SelfID:     selfID,
+		KeyID:      sk.kid,
+		PrivateKey: sk.privateKey(),
+	}
+
+	return transport.NewRest(cfg)
+}
+
+func pk(rest *transport.Rest) *pki.Client {
+	client, err := pki.New(pki.Config{Transport: rest})
 	check(err)
 
-	return ed25519.NewKeyFromSeed(seed)
+	return client
 }
 
+// apiURL returns the url of the api, either as specified by the '--api-url'
+// flag, or the url of the environment being targeted
 func apiURL() string {
-	if v.GetString("self_env") != "" && v.GetString("self_env") != "production" {
-		if v.GetString("self_env") == "dev" {
-			return "http://api:8080"
-		}
-		return "https://api." + v.GetString("self_env") + ".joinself.com"
+	api, err := resolveAPIURL()
+	check(err)
+
+	return api
+}
+
+// resolveAPIURL returns the url of the api, returning an error
+// if the environment being targeted is not valid
+func resolveAPIURL() (string, error) {
+	if v.GetString("self_api_url") != "" {
+		return strings.TrimSuffix(v.GetString("self_api_url"), "/"), nil
+	}
+
+	env, err := currentEnvironment()
+	if err != nil {
+		return "", err
 	}
 
-	return "https://api.joinself.com"
+	return env.APIURL, nil
 }
 
+// log reports the progress of a step to stderr until its outcome is sent on done.
+// A spinner is only shown if stderr is a terminal, otherwise the outcome of the
+// step is written as a single line
 func log(message string, done chan error) {
+	spinners.Add(1)
+	defer spinners.Done()
+
+	st := startStep(message)
+	start := time.Now()
+
+	spinning := !quiet && isTerminal(os.Stderr)
+	if !spinning {
+		verbosef("  -   %s\n", message)
+	}
+
 	s := spin.New()
 
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
 	for {
+		if spinning {
+			fmt.Fprintf(os.Stderr, "\r  %s   %s", paint(os.Stderr, colorSpinner, s.Next()), message)
+		}
+
 		select {
 		case err := <-done:
+			st.finish(err)
+
+			line := message
+			if verbose {
+				line = fmt.Sprintf("%s (%s)", message, time.Since(start).Round(time.Millisecond))
+			}
+
+			if spinning {
+				fmt.Fprint(os.Stderr, "\r")
+			}
+
 			if err != nil {
-				fmt.Printf("\r  \033[1;31m%s   \033[0m%s\n", "✘", message)
-				fmt.Printf("\nerrored with: \n  %s\n", err.Error())
+				errorf("  %s   %s\n", paint(os.Stderr, colorRed, "✘"), line)
+				errorf("\nerrored with: \n  %s\n", err.Error())
 			} else {
-				fmt.Printf("\r  \033[1;32m%s   \033[0m%s\n", "✓", message)
+				progressf("  %s   %s\n", paint(os.Stderr, colorGreen, "✓"), line)
 			}
+
 			return
-		default:
-			fmt.Printf("\r  \033[34m%s   \033[0m%s", s.Next(), message)
-			time.Sleep(100 * time.Millisecond)
+		case <-ticker.C:
 		}
 	}
 }
 
-func newOperation(sg *siggraph.SignatureGraph, actions []siggraph.Action, sk string) json.RawMessage {
-	kp := strings.Split(sk, ":")
+func newOperation(sg *siggraph.SignatureGraph, actions []siggraph.Action, sk *key) json.RawMessage {
+	operation, err := signOperation(sg, actions, sk, ntp.TimeFunc())
+	check(err)
+
+	return operation
+}
 
+// signOperation creates and signs an operation with the given timestamp
+func signOperation(sg *siggraph.SignatureGraph, actions []siggraph.Action, sk *key, timestamp time.Time) (json.RawMessage, error) {
 	op := &siggraph.Operation{
 		Sequence:  sg.NextSequence(),
 		Version:   "1.0.0",
 		Previous:  sg.PreviousSignature(),
-		Timestamp: ntp.TimeFunc().Unix(),
+		Timestamp: timestamp.Unix(),
 		Actions:   actions,
 	}
 
+	s := startSpan("sign operation", map[string]interface{}{
+		"self.kid":      sk.kid,
+		"self.sequence": op.Sequence,
+		"self.actions":  len(actions),
+	})
+
 	data, err := json.Marshal(op)
-	check(err)
+	if err != nil {
+		s.finish(err)
+		return nil, err
+	}
+
+	jws, err := signJWS(sk, data)
+	s.finish(err)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return json.RawMessage(jws.FullSerialize()), nil
+}
 
+// signJWS signs a payload with a secret key, setting the key's
+// identifier as the 'kid' header of the jws
+func signJWS(sk *key, payload []byte) (*jose.JSONWebSignature, error) {
 	opts := &jose.SignerOptions{
 		ExtraHeaders: map[jose.HeaderKey]interface{}{
-			"kid": kp[0],
+			"kid": sk.kid,
 		},
 	}
 
-	seed, err := base64.RawStdEncoding.DecodeString(kp[1])
-	check(err)
-
-	dsk := ed25519.NewKeyFromSeed(seed)
+	s, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.EdDSA, Key: sk.privateKey()}, opts)
+	if err != nil {
+		return nil, err
+	}
 
-	s, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.EdDSA, Key: dsk}, opts)
-	check(err)
+	return s.Sign(payload)
+}
 
-	jws, err := s.Sign(data)
-	check(err)
+// readInput reads the file at a path, or stdin if the path is '-' or empty
+func readInput(path string) ([]byte, error) {
+	if path == "" || path == "-" {
+		return io.ReadAll(os.Stdin)
+	}
 
-	return json.RawMessage(jws.FullSerialize())
+	return os.ReadFile(path)
 }
 
 func check(err error) {
 	if err != nil {
-		fmt.Printf("\nerrored with:\n  %s\n", err.Error())
-		os.Exit(1)
+		errorf("\nerrored with:\n  %s\n", err.Error())
+		exit(1)
 	}
 }
@@ -0,0 +1,198 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/joinself/self-go-sdk/pkg/ntp"
+	"github.com/joinself/self-go-sdk/pkg/siggraph"
+	"github.com/joinself/self-go-sdk/pkg/transport"
+	"github.com/spf13/viper"
+)
+
+// testRest returns a client for a stand-in api, authenticated as an app identity
+func testRest(t *testing.T, handler http.Handler) *transport.Rest {
+	t.Helper()
+
+	testAPI(t, handler)
+
+	return rest(context.Background(), "app", generateKey(keyTypeSecret, "1"))
+}
+
+// testAPI targets a stand-in api for the duration of a test
+func testAPI(t *testing.T, handler http.Handler) {
+	t.Helper()
+
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+
+	previous := v
+	t.Cleanup(func() { v = previous })
+
+	v = viper.New()
+	v.Set("self_api_url", srv.URL)
+
+	// sign requests with the local clock, instead of querying an ntp server
+	timeFunc := ntp.TimeFunc
+	t.Cleanup(func() { ntp.TimeFunc = timeFunc })
+
+	ntp.TimeFunc = time.Now
+}
+
+// testIdentity an identity with a history, for building stand-in api responses
+type testIdentity struct {
+	selfID  string
+	history []json.RawMessage
+	keys    map[string]*key
+	devices []string
+}
+
+// newTestIdentity creates an identity with a device key for each device, signed
+// by the first device's key, and a recovery key. Keys are numbered in order,
+// with the recovery key last
+func newTestIdentity(t *testing.T, selfID string, createdAt time.Time, devices ...string) *testIdentity {
+	t.Helper()
+
+	ti := &testIdentity{selfID: selfID, keys: make(map[string]*key), devices: devices}
+
+	var actions []siggraph.Action
+
+	for n, did := range devices {
+		k := generateKey(keyTypeSecret, strconv.Itoa(n+1))
+		ti.keys[k.kid] = k
+
+		actions = append(actions, siggraph.Action{
+			KID:           k.kid,
+			DID:           did,
+			Type:          siggraph.TypeDeviceKey,
+			Action:        siggraph.ActionKeyAdd,
+			EffectiveFrom: createdAt.Unix(),
+			Key:           enc.EncodeToString(k.publicKey()),
+		})
+	}
+
+	rk := generateKey(keyTypeRecovery, strconv.Itoa(len(devices)+1))
+	ti.keys[rk.kid] = rk
+
+	actions = append(actions, siggraph.Action{
+		KID:           rk.kid,
+		Type:          siggraph.TypeRecoveryKey,
+		Action:        siggraph.ActionKeyAdd,
+		EffectiveFrom: createdAt.Unix(),
+		Key:           enc.EncodeToString(rk.publicKey()),
+	})
+
+	root, err := json.Marshal(&siggraph.Operation{
+		Sequence:  0,
+		Version:   "1.0.0",
+		Timestamp: createdAt.Unix(),
+		Actions:   actions,
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jws, err := signJWS(ti.keys["1"], root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ti.history = []json.RawMessage{json.RawMessage(jws.FullSerialize())}
+
+	return ti
+}
+
+// add signs and appends an operation to the identity's history
+func (ti *testIdentity) add(t *testing.T, sk *key, timestamp time.Time, actions ...siggraph.Action) {
+	t.Helper()
+
+	sg, err := siggraph.New(ti.history)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	operation, err := signOperation(sg, actions, sk, timestamp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = sg.Execute(operation)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ti.history = append(ti.history, operation)
+}
+
+// graph builds the identity's signature graph
+func (ti *testIdentity) graph(t *testing.T) *siggraph.SignatureGraph {
+	t.Helper()
+
+	sg, err := siggraph.New(ti.history)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return sg
+}
+
+// ServeHTTP serves the identity and its history as the api would
+func (ti *testIdentity) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/v1/identities/"+ti.selfID:
+		json.NewEncoder(w).Encode(Identity{SelfID: ti.selfID, Type: "app", History: ti.history})
+	case r.Method == http.MethodGet && r.URL.Path == "/v1/identities/"+ti.selfID+"/devices":
+		json.NewEncoder(w).Encode(ti.devices)
+	case r.Method == http.MethodPost && r.URL.Path == "/v1/identities/"+ti.selfID+"/history":
+		data, _ := io.ReadAll(r.Body)
+		ti.history = append(ti.history, json.RawMessage(data))
+		w.WriteHeader(http.StatusCreated)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// captureOutput returns everything written to a file, such as stdout, while running a function
+func captureOutput(t *testing.T, f **os.File, fn func()) string {
+	t.Helper()
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+
+	copied := make(chan struct{})
+
+	go func() {
+		io.Copy(&out, r)
+		close(copied)
+	}()
+
+	func() {
+		previous := *f
+		defer func() { *f = previous }()
+
+		*f = w
+
+		fn()
+	}()
+
+	w.Close()
+	<-copied
+	r.Close()
+
+	return out.String()
+}
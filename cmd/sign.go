@@ -0,0 +1,85 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	jwsFormatCompact = "compact"
+	jwsFormatJSON    = "json"
+)
+
+var (
+	jwsFormat string
+	detached  bool
+)
+
+var signCommand = &cobra.Command{
+	Use:   "sign",
+	Short: "signs a payload with a device key",
+	Long:  "signs a payload from a file, or from stdin if the file is '-' or omitted, producing a jws with the device's key identifier as its 'kid' header",
+	Run: func(cmd *cobra.Command, args []string) {
+		sk := mustSecretKey(secretKey, keyTypeSecret, "secret key")
+
+		if jwsFormat != jwsFormatCompact && jwsFormat != jwsFormatJSON {
+			check(errors.New("jws format must be one of [compact, json]"))
+		}
+
+		var path string
+		if len(args) > 0 {
+			path = args[0]
+		}
+
+		payload, err := readInput(path)
+		check(err)
+
+		jws, err := signJWS(sk, payload)
+		check(err)
+
+		var output string
+
+		switch {
+		case jwsFormat == jwsFormatCompact && detached:
+			output, err = jws.DetachedCompactSerialize()
+		case jwsFormat == jwsFormatCompact:
+			output, err = jws.CompactSerialize()
+		case detached:
+			output, err = detachedFullSerialize(jws.FullSerialize())
+		default:
+			output = jws.FullSerialize()
+		}
+
+		check(err)
+
+		fmt.Println(output)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(signCommand)
+	signCommand.Flags().StringVarP(&secretKey, "key", "k", "", "Device secret key to sign with")
+	signCommand.Flags().StringVarP(&jwsFormat, "format", "f", jwsFormatJSON, "Serialization of the jws [compact, json]")
+	signCommand.Flags().BoolVarP(&detached, "detached", "d", false, "Omit the payload from the jws")
+}
+
+// detachedFullSerialize removes the payload from a json serialized jws
+func detachedFullSerialize(jws string) (string, error) {
+	var m map[string]json.RawMessage
+
+	err := json.Unmarshal([]byte(jws), &m)
+	if err != nil {
+		return "", err
+	}
+
+	delete(m, "payload")
+
+	data, err := json.Marshal(m)
+
+	return string(data), err
+}
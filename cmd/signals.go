@@ -0,0 +1,204 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+var (
+	interrupted int32
+
+	steps   []*step
+	stepsMu sync.Mutex
+
+	spinners sync.WaitGroup
+)
+
+// step a step of a command, as reported by a spinner
+type step struct {
+	message string
+	done    bool
+	err     error
+}
+
+// contextTransport sends requests with the commands context, as the
+// sdk's rest client does not support passing a context to requests
+type contextTransport struct {
+	ctx  context.Context
+	next http.RoundTripper
+}
+
+// detachedContext a context that carries its parents values, but is never cancelled
+type detachedContext struct {
+	context.Context
+}
+
+// signalContext returns a context that is cancelled when the process receives
+// SIGINT or SIGTERM, so that the in-flight step can either finish or be safely
+// aborted. A second signal exits immediately
+func signalContext() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	sigs := make(chan os.Signal, 2)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		<-sigs
+
+		atomic.StoreInt32(&interrupted, 1)
+		cancel()
+
+		fmt.Fprintln(os.Stderr, "\n\ninterrupted, stopping after the in-flight step. Interrupt again to exit immediately")
+
+		<-sigs
+
+		reportSteps()
+		os.Exit(130)
+	}()
+
+	return ctx
+}
+
+// isInterrupted returns true if the command has been interrupted
+func isInterrupted() bool {
+	return atomic.LoadInt32(&interrupted) == 1
+}
+
+// exit exits with the given status code once any spinners have finished
+// writing. If the command was interrupted, the steps that were completed
+// are reported
+func exit(code int) {
+	finished := make(chan struct{})
+
+	go func() {
+		spinners.Wait()
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+	case <-time.After(time.Second):
+	}
+
+	if isInterrupted() {
+		reportSteps()
+		code = 130
+	}
+
+	endTrace(code)
+
+	os.Exit(code)
+}
+
+// startStep records the start of a step
+func startStep(message string) *step {
+	stepsMu.Lock()
+	defer stepsMu.Unlock()
+
+	s := &step{message: message}
+	steps = append(steps, s)
+
+	return s
+}
+
+// finish records the outcome of a step
+func (s *step) finish(err error) {
+	stepsMu.Lock()
+	defer stepsMu.Unlock()
+
+	s.done = err == nil
+	s.err = err
+}
+
+// reportSteps writes the steps that were and were not completed to stderr
+func reportSteps() {
+	stepsMu.Lock()
+	defer stepsMu.Unlock()
+
+	var completed, incomplete []string
+
+	for _, s := range steps {
+		if s.done {
+			completed = append(completed, "  "+paint(os.Stderr, colorGreen, "✓")+" "+s.message)
+		} else {
+			incomplete = append(incomplete, "  "+paint(os.Stderr, colorRed, "✘")+" "+s.message)
+		}
+	}
+
+	if len(completed) > 0 {
+		fmt.Fprintf(os.Stderr, "\ncompleted steps:\n%s\n", strings.Join(completed, "\n"))
+	}
+
+	if len(incomplete) > 0 {
+		fmt.Fprintf(os.Stderr, "\naborted steps:\n%s\n", strings.Join(incomplete, "\n"))
+	}
+
+	if len(completed) == 0 && len(incomplete) == 0 {
+		fmt.Fprintln(os.Stderr, "\nno steps were started")
+	}
+}
+
+// RoundTrip sends a request with the commands context. Requests are not started if
+// the command has been interrupted, but operations posted to an identities history
+// are allowed to complete, as they can't be undone once they have been sent
+func (t *contextTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.ctx.Err() != nil {
+		return nil, t.ctx.Err()
+	}
+
+	ctx := t.ctx
+
+	if req.Method == http.MethodPost && strings.HasSuffix(req.URL.Path, "/history") {
+		ctx = detach(ctx)
+	}
+
+	return t.next.RoundTrip(req.WithContext(ctx))
+}
+
+// detach returns a context that is not cancelled when its parent is
+func detach(ctx context.Context) context.Context {
+	return detachedContext{ctx}
+}
+
+func (detachedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (detachedContext) Err() error {
+	return nil
+}
+
+// serve serves http requests until the context is cancelled
+func serve(ctx context.Context, addr string, handler http.Handler) error {
+	srv := &http.Server{Addr: addr, Handler: handler}
+
+	go func() {
+		<-ctx.Done()
+
+		shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		srv.Shutdown(shutdown)
+	}()
+
+	err := srv.ListenAndServe()
+	if err == http.ErrServerClosed {
+		return nil
+	}
+
+	return err
+}
@@ -0,0 +1,181 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+)
+
+// recordingTransport records whether the context of each request it sends was cancelled
+type recordingTransport struct {
+	requests  []string
+	cancelled []bool
+	// interrupt is called once the request has been started
+	interrupt func()
+}
+
+func (t *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.interrupt != nil {
+		t.interrupt()
+	}
+
+	t.requests = append(t.requests, req.Method+" "+req.URL.Path)
+	t.cancelled = append(t.cancelled, req.Context().Err() != nil)
+
+	return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody, Request: req}, nil
+}
+
+func TestContextTransport(t *testing.T) {
+	tests := []struct {
+		name      string
+		before    bool
+		during    bool
+		method    string
+		path      string
+		sent      bool
+		cancelled bool
+	}{
+		{"get", false, false, http.MethodGet, "/v1/identities/app", true, false},
+		{"post history", false, false, http.MethodPost, "/v1/identities/app/history", true, false},
+		{"get after interrupt", true, false, http.MethodGet, "/v1/identities/app", false, false},
+		{"post history after interrupt", true, false, http.MethodPost, "/v1/identities/app/history", false, false},
+		{"get interrupted", false, true, http.MethodGet, "/v1/identities/app", true, true},
+		{"post interrupted", false, true, http.MethodPost, "/v1/identities/app/devices", true, true},
+		{"post history interrupted", false, true, http.MethodPost, "/v1/identities/app/history", true, false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			next := &recordingTransport{}
+			if tc.during {
+				next.interrupt = cancel
+			}
+
+			transport := &contextTransport{ctx: ctx, next: next}
+
+			req, err := http.NewRequest(tc.method, "https://api.joinself.com"+tc.path, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tc.before {
+				cancel()
+			}
+
+			_, err = transport.RoundTrip(req)
+
+			if !tc.sent {
+				if !errors.Is(err, context.Canceled) || len(next.requests) > 0 {
+					t.Fatalf("expected the request not to be sent, got %v %v", next.requests, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(next.requests) != 1 || next.cancelled[0] != tc.cancelled {
+				t.Fatalf("expected one request with cancelled %t, got %v %v", tc.cancelled, next.requests, next.cancelled)
+			}
+		})
+	}
+}
+
+func TestDetach(t *testing.T) {
+	type contextKey struct{}
+
+	parent, cancel := context.WithCancel(context.WithValue(context.Background(), contextKey{}, "value"))
+
+	ctx := detach(parent)
+
+	cancel()
+
+	if parent.Err() == nil {
+		t.Fatal("expected the parent context to be cancelled")
+	}
+
+	if ctx.Err() != nil || ctx.Done() != nil {
+		t.Fatal("expected the detached context not to be cancelled with its parent")
+	}
+
+	if _, ok := ctx.Deadline(); ok {
+		t.Fatal("expected the detached context to have no deadline")
+	}
+
+	if ctx.Value(contextKey{}) != "value" {
+		t.Fatal("expected the detached context to carry its parents values")
+	}
+}
+
+func TestReportSteps(t *testing.T) {
+	t.Setenv("NO_COLOR", "1")
+
+	type testStep struct {
+		message  string
+		finished bool
+		err      error
+	}
+
+	tests := []struct {
+		name     string
+		steps    []testStep
+		expected string
+	}{
+		{
+			name:     "no steps",
+			expected: "\nno steps were started\n",
+		},
+		{
+			name: "interrupted",
+			steps: []testStep{
+				{"adding device", true, nil},
+				{"activating key", true, nil},
+				{"revoking device", true, context.Canceled},
+				{"notifying device", false, nil},
+			},
+			expected: strings.Join([]string{
+				"",
+				"completed steps:",
+				"  ✓ adding device",
+				"  ✓ activating key",
+				"",
+				"aborted steps:",
+				"  ✘ revoking device",
+				"  ✘ notifying device",
+				"",
+			}, "\n"),
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			previous := steps
+			defer func() { steps = previous }()
+
+			steps = nil
+
+			for _, ts := range tc.steps {
+				s := startStep(ts.message)
+
+				if ts.finished {
+					s.finish(ts.err)
+				}
+			}
+
+			out := captureOutput(t, &os.Stderr, reportSteps)
+
+			if out != tc.expected {
+				t.Fatalf("expected:\n%s\ngot:\n%s", tc.expected, out)
+			}
+		})
+	}
+}
@@ -0,0 +1,378 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package cmd
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/joinself/self-go-sdk/pkg/siggraph"
+	"github.com/spf13/cobra"
+)
+
+const (
+	spanKindInternal = 1
+	spanKindClient   = 3
+
+	spanStatusOK    = 1
+	spanStatusError = 2
+)
+
+var (
+	traceID     string
+	traceParent string
+	commandSpan *span
+
+	spans   []*span
+	spansMu sync.Mutex
+
+	// traceParentPattern a w3c trace context 'traceparent'
+	traceParentPattern = regexp.MustCompile(`^00-([0-9a-f]{32})-([0-9a-f]{16})-[0-9a-f]{2}$`)
+
+	// identityPath an api path that refers to an identity
+	identityPath = regexp.MustCompile(`^/v1/(identities|apps)/([^/]+)`)
+)
+
+// span an operation traced by the cli, exported as an otlp span
+type span struct {
+	id         string
+	parent     string
+	name       string
+	kind       int
+	start      time.Time
+	end        time.Time
+	attributes map[string]interface{}
+	err        error
+}
+
+// tracingTransport traces each request made to the api, propagating the
+// trace context to the api with the 'traceparent' header
+type tracingTransport struct {
+	next http.RoundTripper
+}
+
+func init() {
+	rootCmd.PersistentPreRun = func(cmd *cobra.Command, args []string) {
+		startTrace(cmd)
+	}
+}
+
+// tracingEnabled returns true if an otlp endpoint to export traces to has been configured
+func tracingEnabled() bool {
+	return tracesEndpoint() != ""
+}
+
+// tracesEndpoint returns the otlp/http endpoint that traces are exported to, as specified
+// by 'OTEL_EXPORTER_OTLP_TRACES_ENDPOINT' or 'OTEL_EXPORTER_OTLP_ENDPOINT'
+func tracesEndpoint() string {
+	if v == nil {
+		return ""
+	}
+
+	if os.Getenv("OTEL_SDK_DISABLED") == "true" {
+		return ""
+	}
+
+	if endpoint := v.GetString("otel_exporter_otlp_traces_endpoint"); endpoint != "" {
+		return endpoint
+	}
+
+	if endpoint := v.GetString("otel_exporter_otlp_endpoint"); endpoint != "" {
+		return strings.TrimSuffix(endpoint, "/") + "/v1/traces"
+	}
+
+	return ""
+}
+
+// startTrace starts the span for the command being run. If 'TRACEPARENT' is set,
+// i.e. by a ci pipeline or deployment tool, the command is traced as part of it
+func startTrace(cmd *cobra.Command) {
+	if !tracingEnabled() {
+		return
+	}
+
+	m := traceParentPattern.FindStringSubmatch(os.Getenv("TRACEPARENT"))
+	if m != nil {
+		traceID = m[1]
+		traceParent = m[2]
+	} else {
+		traceID = randomID(16)
+	}
+
+	commandSpan = startSpan(cmd.CommandPath(), map[string]interface{}{
+		"self.env": v.GetString("self_env"),
+	})
+
+	commandSpan.parent = traceParent
+}
+
+// startSpan starts a span as a child of the command's span. If tracing
+// is not enabled, the span is not recorded
+func startSpan(name string, attributes map[string]interface{}) *span {
+	s := &span{
+		id:         randomID(8),
+		name:       name,
+		kind:       spanKindInternal,
+		start:      time.Now(),
+		attributes: attributes,
+	}
+
+	if commandSpan != nil {
+		s.parent = commandSpan.id
+	}
+
+	if attributes == nil {
+		s.attributes = make(map[string]interface{})
+	}
+
+	return s
+}
+
+// finish ends a span, recording any error
+func (s *span) finish(err error) {
+	if commandSpan == nil {
+		return
+	}
+
+	s.end = time.Now()
+	s.err = err
+
+	spansMu.Lock()
+	defer spansMu.Unlock()
+
+	spans = append(spans, s)
+}
+
+// traceParentHeader returns the 'traceparent' header for a span
+func (s *span) traceParentHeader() string {
+	return "00-" + traceID + "-" + s.id + "-01"
+}
+
+// loadGraph builds an identities signature graph from its history
+func loadGraph(selfID string, history []json.RawMessage) (*siggraph.SignatureGraph, error) {
+	s := startSpan("build signature graph", map[string]interface{}{
+		"self.id":         selfID,
+		"self.operations": len(history),
+	})
+
+	sg, err := siggraph.New(history)
+	s.finish(err)
+
+	return sg, err
+}
+
+// executeOperation validates an operation by executing it against an identities signature graph
+func executeOperation(selfID string, sg *siggraph.SignatureGraph, operation []byte) error {
+	s := startSpan("validate operation", map[string]interface{}{
+		"self.id":       selfID,
+		"self.sequence": sg.NextSequence(),
+	})
+
+	err := sg.Execute(operation)
+	s.finish(err)
+
+	return err
+}
+
+// RoundTrip sends a request as a span of the command's trace
+func (t *tracingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	route := identityPath.ReplaceAllString(req.URL.Path, "/v1/$1/{self_id}")
+
+	s := startSpan(req.Method+" "+route, map[string]interface{}{
+		"http.request.method": req.Method,
+		"url.path":            req.URL.Path,
+		"server.address":      req.URL.Host,
+	})
+
+	s.kind = spanKindClient
+
+	if m := identityPath.FindStringSubmatch(req.URL.Path); m != nil {
+		s.attributes["self.id"] = m[2]
+	}
+
+	r := req.Clone(req.Context())
+	r.Header.Set("traceparent", s.traceParentHeader())
+
+	resp, err := t.next.RoundTrip(r)
+	if err != nil {
+		s.finish(err)
+		return nil, err
+	}
+
+	s.attributes["http.response.status_code"] = resp.StatusCode
+
+	if resp.StatusCode >= 400 {
+		s.finish(fmt.Errorf("%s %s returned %s", req.Method, req.URL.Path, resp.Status))
+	} else {
+		s.finish(nil)
+	}
+
+	return resp, nil
+}
+
+// endTrace ends the command's span with the command's exit code,
+// and exports the trace to the otlp endpoint
+func endTrace(code int) {
+	if commandSpan == nil {
+		return
+	}
+
+	commandSpan.attributes["process.exit.code"] = code
+
+	var err error
+	if code != 0 {
+		err = fmt.Errorf("exited with status %d", code)
+	}
+
+	commandSpan.finish(err)
+
+	err = exportTrace()
+	if err != nil {
+		verbosef("failed to export trace: %s\n", err.Error())
+	}
+}
+
+// exportTrace exports the recorded spans to the otlp endpoint, encoded as otlp/json
+func exportTrace() error {
+	spansMu.Lock()
+	defer spansMu.Unlock()
+
+	var encoded []map[string]interface{}
+
+	for _, s := range spans {
+		encoded = append(encoded, s.otlp())
+	}
+
+	serviceName := v.GetString("otel_service_name")
+	if serviceName == "" {
+		serviceName = rootCmd.Name()
+	}
+
+	data, err := json.Marshal(map[string]interface{}{
+		"resourceSpans": []interface{}{
+			map[string]interface{}{
+				"resource": map[string]interface{}{
+					"attributes": otlpAttributes(map[string]interface{}{
+						"service.name": serviceName,
+					}),
+				},
+				"scopeSpans": []interface{}{
+					map[string]interface{}{
+						"scope": map[string]interface{}{
+							"name": rootCmd.Name(),
+						},
+						"spans": encoded,
+					},
+				},
+			},
+		},
+	})
+
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, tracesEndpoint(), bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	// headers are specified as 'key=value' pairs, separated by commas
+	for _, h := range strings.Split(v.GetString("otel_exporter_otlp_headers"), ",") {
+		kv := strings.SplitN(h, "=", 2)
+		if len(kv) == 2 {
+			req.Header.Set(strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1]))
+		}
+	}
+
+	client := &http.Client{
+		Timeout:   5 * time.Second,
+		Transport: &http.Transport{Proxy: http.ProxyFromEnvironment},
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("otlp endpoint returned %s", resp.Status)
+	}
+
+	return nil
+}
+
+// otlp encodes a span as an otlp/json span
+func (s *span) otlp() map[string]interface{} {
+	encoded := map[string]interface{}{
+		"traceId":           traceID,
+		"spanId":            s.id,
+		"name":              s.name,
+		"kind":              s.kind,
+		"startTimeUnixNano": strconv.FormatInt(s.start.UnixNano(), 10),
+		"endTimeUnixNano":   strconv.FormatInt(s.end.UnixNano(), 10),
+		"attributes":        otlpAttributes(s.attributes),
+		"status":            map[string]interface{}{"code": spanStatusOK},
+	}
+
+	if s.parent != "" {
+		encoded["parentSpanId"] = s.parent
+	}
+
+	if s.err != nil {
+		encoded["status"] = map[string]interface{}{
+			"code":    spanStatusError,
+			"message": redact(s.err.Error()),
+		}
+	}
+
+	return encoded
+}
+
+// otlpAttributes encodes attributes as otlp/json key values
+func otlpAttributes(attributes map[string]interface{}) []interface{} {
+	var encoded []interface{}
+
+	for k, a := range attributes {
+		var value map[string]interface{}
+
+		switch av := a.(type) {
+		case int:
+			value = map[string]interface{}{"intValue": strconv.Itoa(av)}
+		case int64:
+			value = map[string]interface{}{"intValue": strconv.FormatInt(av, 10)}
+		case bool:
+			value = map[string]interface{}{"boolValue": av}
+		default:
+			value = map[string]interface{}{"stringValue": fmt.Sprint(av)}
+		}
+
+		encoded = append(encoded, map[string]interface{}{"key": k, "value": value})
+	}
+
+	return encoded
+}
+
+// randomID returns a random hex encoded trace or span id
+func randomID(size int) string {
+	id := make([]byte, size)
+
+	_, err := rand.Read(id)
+	check(err)
+
+	return hex.EncodeToString(id)
+}
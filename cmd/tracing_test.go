@@ -0,0 +1,358 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// otlpRequest an otlp/json export request, as received by a collector
+type otlpRequest struct {
+	ResourceSpans []struct {
+		Resource struct {
+			Attributes []otlpKeyValue `json:"attributes"`
+		} `json:"resource"`
+		ScopeSpans []struct {
+			Spans []otlpSpan `json:"spans"`
+		} `json:"scopeSpans"`
+	} `json:"resourceSpans"`
+}
+
+type otlpSpan struct {
+	TraceID      string         `json:"traceId"`
+	SpanID       string         `json:"spanId"`
+	ParentSpanID string         `json:"parentSpanId"`
+	Name         string         `json:"name"`
+	Kind         int            `json:"kind"`
+	Start        string         `json:"startTimeUnixNano"`
+	End          string         `json:"endTimeUnixNano"`
+	Attributes   []otlpKeyValue `json:"attributes"`
+	Status       struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"status"`
+}
+
+type otlpKeyValue struct {
+	Key   string                 `json:"key"`
+	Value map[string]interface{} `json:"value"`
+}
+
+// attribute returns the value of an attribute
+func attribute(attributes []otlpKeyValue, key string) interface{} {
+	for _, a := range attributes {
+		if a.Key != key {
+			continue
+		}
+
+		for _, value := range a.Value {
+			return value
+		}
+	}
+
+	return nil
+}
+
+// testCollector a stand-in otlp/http collector that records the traces exported to it
+type testCollector struct {
+	mu       sync.Mutex
+	requests []otlpRequest
+	headers  []http.Header
+}
+
+func (c *testCollector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/v1/traces" || r.Header.Get("Content-Type") != "application/json" {
+		http.NotFound(w, r)
+		return
+	}
+
+	var req otlpRequest
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.requests = append(c.requests, req)
+	c.headers = append(c.headers, r.Header.Clone())
+}
+
+// startTestTrace starts tracing a command, exporting the trace to a stand-in collector
+func startTestTrace(t *testing.T, collector http.Handler, parent string) {
+	t.Helper()
+
+	srv := httptest.NewServer(collector)
+	t.Cleanup(srv.Close)
+
+	previous := v
+
+	t.Cleanup(func() {
+		v = previous
+		commandSpan = nil
+		traceID = ""
+		traceParent = ""
+		spans = nil
+	})
+
+	t.Setenv("TRACEPARENT", parent)
+	t.Setenv("OTEL_SDK_DISABLED", "")
+
+	v = viper.New()
+	v.Set("self_env", "test")
+	v.Set("otel_exporter_otlp_endpoint", srv.URL+"/")
+	v.Set("otel_exporter_otlp_headers", "Authorization=Bearer collector-token, X-Tenant = self")
+	v.Set("otel_service_name", "self-cli-test")
+
+	startTrace(&cobra.Command{Use: "test"})
+
+	if commandSpan == nil {
+		t.Fatal("expected the command to be traced")
+	}
+}
+
+func TestTracing(t *testing.T) {
+	collector := &testCollector{}
+
+	startTestTrace(t, collector, "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+
+	var apiHeaders []http.Header
+
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		apiHeaders = append(apiHeaders, r.Header.Clone())
+
+		if strings.HasSuffix(r.URL.Path, "/devices") {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+
+	defer api.Close()
+
+	client := &http.Client{Transport: &tracingTransport{next: http.DefaultTransport}}
+
+	for _, path := range []string{"/v1/identities/app", "/v1/apps/app/devices"} {
+		resp, err := client.Get(api.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resp.Body.Close()
+	}
+
+	s := startSpan("build signature graph", map[string]interface{}{"self.operations": 3})
+	s.finish(errors.New("invalid signature sk_1:" + strings.Repeat("a", 43)))
+
+	endTrace(1)
+
+	if len(collector.requests) != 1 {
+		t.Fatalf("expected one export, got %d", len(collector.requests))
+	}
+
+	h := collector.headers[0]
+	if h.Get("Authorization") != "Bearer collector-token" || h.Get("X-Tenant") != "self" {
+		t.Fatalf("expected the otlp headers to be sent, got %v", h)
+	}
+
+	req := collector.requests[0]
+
+	if len(req.ResourceSpans) != 1 || len(req.ResourceSpans[0].ScopeSpans) != 1 {
+		t.Fatalf("unexpected export %+v", req)
+	}
+
+	if attribute(req.ResourceSpans[0].Resource.Attributes, "service.name") != "self-cli-test" {
+		t.Fatal("expected the service name to be set")
+	}
+
+	spans := make(map[string]otlpSpan)
+
+	for _, s := range req.ResourceSpans[0].ScopeSpans[0].Spans {
+		spans[s.Name] = s
+	}
+
+	if len(spans) != 4 {
+		t.Fatalf("expected 4 spans, got %v", spans)
+	}
+
+	cmd := spans["test"]
+
+	// the command is traced as part of the trace it was started from
+	if cmd.TraceID != "0af7651916cd43dd8448eb211c80319c" || cmd.ParentSpanID != "b7ad6b7169203331" {
+		t.Fatalf("expected the command span to continue the parent trace, got %+v", cmd)
+	}
+
+	if cmd.Status.Code != spanStatusError || attribute(cmd.Attributes, "process.exit.code") != "1" {
+		t.Fatalf("expected the command span to record the exit code, got %+v", cmd)
+	}
+
+	if attribute(cmd.Attributes, "url.full") != nil {
+		t.Fatal("expected the command span to not resolve the api url")
+	}
+
+	get := spans["GET /v1/identities/{self_id}"]
+	devices := spans["GET /v1/apps/{self_id}/devices"]
+	graph := spans["build signature graph"]
+
+	for _, s := range []otlpSpan{get, devices, graph} {
+		if s.TraceID != cmd.TraceID || s.ParentSpanID != cmd.SpanID {
+			t.Fatalf("expected span %s to be a child of the command span, got %+v", s.Name, s)
+		}
+
+		if s.Start == "" || s.End < s.Start {
+			t.Fatalf("expected span %s to have a start and end time, got %+v", s.Name, s)
+		}
+	}
+
+	if get.Kind != spanKindClient || get.Status.Code != spanStatusOK {
+		t.Fatalf("unexpected request span %+v", get)
+	}
+
+	if attribute(get.Attributes, "self.id") != "app" || attribute(get.Attributes, "http.response.status_code") != "200" {
+		t.Fatalf("unexpected request span attributes %+v", get.Attributes)
+	}
+
+	if devices.Status.Code != spanStatusError || attribute(devices.Attributes, "http.response.status_code") != "500" {
+		t.Fatalf("expected the failed request to be recorded as an error, got %+v", devices)
+	}
+
+	if graph.Status.Code != spanStatusError || strings.Contains(graph.Status.Message, "sk_1:") {
+		t.Fatalf("expected the error to be recorded with secrets redacted, got %+v", graph.Status)
+	}
+
+	if attribute(graph.Attributes, "self.operations") != "3" {
+		t.Fatalf("unexpected span attributes %+v", graph.Attributes)
+	}
+
+	// the trace context is propagated to the api, with each request as the parent
+	for n, s := range []otlpSpan{get, devices} {
+		expected := "00-" + cmd.TraceID + "-" + s.SpanID + "-01"
+
+		if apiHeaders[n].Get("traceparent") != expected {
+			t.Fatalf("expected traceparent %s, got %s", expected, apiHeaders[n].Get("traceparent"))
+		}
+	}
+}
+
+func TestTracingNewTrace(t *testing.T) {
+	collector := &testCollector{}
+
+	startTestTrace(t, collector, "invalid")
+
+	endTrace(0)
+
+	if len(collector.requests) != 1 {
+		t.Fatalf("expected one export, got %d", len(collector.requests))
+	}
+
+	s := collector.requests[0].ResourceSpans[0].ScopeSpans[0].Spans[0]
+
+	if len(s.TraceID) != 32 || s.TraceID == "0af7651916cd43dd8448eb211c80319c" || s.ParentSpanID != "" {
+		t.Fatalf("expected a new trace to be started, got %+v", s)
+	}
+
+	if s.Status.Code != spanStatusOK {
+		t.Fatalf("expected the command span to be ok, got %+v", s.Status)
+	}
+}
+
+func TestTracesEndpoint(t *testing.T) {
+	previous := v
+	defer func() { v = previous }()
+
+	tests := []struct {
+		name     string
+		endpoint string
+		traces   string
+		disabled string
+		expected string
+	}{
+		{"not configured", "", "", "", ""},
+		{"endpoint", "http://collector:4318", "", "", "http://collector:4318/v1/traces"},
+		{"traces endpoint", "http://collector:4318", "http://traces:4318/custom", "", "http://traces:4318/custom"},
+		{"disabled", "http://collector:4318", "", "true", ""},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("OTEL_SDK_DISABLED", tc.disabled)
+
+			v = viper.New()
+			v.Set("otel_exporter_otlp_endpoint", tc.endpoint)
+			v.Set("otel_exporter_otlp_traces_endpoint", tc.traces)
+
+			if tracesEndpoint() != tc.expected {
+				t.Fatalf("expected endpoint '%s', got '%s'", tc.expected, tracesEndpoint())
+			}
+		})
+	}
+}
+
+func TestTracingNotConfigured(t *testing.T) {
+	previous := v
+	defer func() { v = previous }()
+
+	v = viper.New()
+
+	startTrace(&cobra.Command{Use: "test"})
+
+	if commandSpan != nil {
+		t.Fatal("expected the command to not be traced")
+	}
+
+	// spans are not recorded
+	startSpan("span", nil).finish(nil)
+
+	if len(spans) != 0 {
+		t.Fatalf("expected no spans to be recorded, got %d", len(spans))
+	}
+}
+
+func TestOTLPAttributes(t *testing.T) {
+	encoded, err := json.Marshal(otlpAttributes(map[string]interface{}{
+		"int":    3,
+		"int64":  int64(4),
+		"bool":   true,
+		"string": "value",
+	}))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var attributes []otlpKeyValue
+
+	err = json.Unmarshal(encoded, &attributes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{
+		"int":    `{"intValue":"3"}`,
+		"int64":  `{"intValue":"4"}`,
+		"bool":   `{"boolValue":true}`,
+		"string": `{"stringValue":"value"}`,
+	}
+
+	for _, a := range attributes {
+		value, _ := json.Marshal(a.Value)
+
+		if string(value) != expected[a.Key] {
+			t.Fatalf("expected attribute %s to be encoded as %s, got %s", a.Key, expected[a.Key], value)
+		}
+	}
+
+	if len(attributes) != len(expected) {
+		t.Fatalf("expected %d attributes, got %d", len(expected), len(attributes))
+	}
+}
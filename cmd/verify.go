@@ -0,0 +1,231 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/joinself/self-go-sdk/pkg/siggraph"
+	"github.com/spf13/cobra"
+	"github.com/square/go-jose"
+)
+
+var payloadFile string
+
+var verifyCommand = &cobra.Command{
+	Use:   "verify",
+	Short: "verifies a jws against an identities key history",
+	Long:  "verifies a jws was signed by a key that was valid on the identities signature graph at the time the payload was signed. The jws may be provided directly, as a file, or from stdin if '-' or omitted. Unless a time is specified with '--at', the time is taken from the payload's 'iat' or 'timestamp' field, which is set by the signer, so a compromised key can sign payloads that claim to have been signed before it was revoked",
+	Run: func(cmd *cobra.Command, args []string) {
+		if selfID == "" {
+			check(errors.New("you must specify the identity that signed the jws [--self-id]"))
+		}
+
+		var input string
+		if len(args) > 0 {
+			input = args[0]
+		}
+
+		jws, payload, err := parseJWS(input, payloadFile)
+		check(err)
+
+		var identity *Identity
+		var sg *siggraph.SignatureGraph
+
+		if historyFile != "" {
+			identity, sg, err = loadIdentity(historyFile)
+			check(err)
+
+			check(checkHistoryIdentity(identity, selfID))
+
+			if identity.SelfID == "" {
+				errorf("%s the history does not specify which identity it belongs to, make sure it is the history of %s\n", paint(os.Stderr, colorYellow, "warning:"), selfID)
+			}
+		} else {
+			sk := mustSecretKey(secretKey, keyTypeSecret, "secret key")
+
+			if appID == "" {
+				appID = selfID
+			}
+
+			identity, sg = getIdentity(rest(cmd.Context(), appID, sk), selfID)
+		}
+
+		keys, err := keyHistory(identity.History, sg)
+		check(err)
+
+		kid := jws.Signatures[0].Header.KeyID
+		if kid == "" {
+			check(errors.New("the jws does not specify a key identifier in its 'kid' header"))
+		}
+
+		k := findKey(keys, kid)
+		if k == nil {
+			check(fmt.Errorf("the key '%s' does not exist on the identities signature graph", kid))
+		}
+
+		progressf("\n")
+		fmt.Println("key id:        ", k.KID)
+		if k.DID != "" {
+			fmt.Println("device id:     ", k.DID)
+		}
+		fmt.Println("key type:      ", k.Type)
+		fmt.Println("key created:   ", formatTime(k.CreatedAt))
+		fmt.Println("key revoked:   ", formatTime(k.RevokedAt))
+
+		_, err = jws.Verify(k.PublicKey)
+		if err != nil {
+			fmt.Println("")
+			fmt.Println(paint(os.Stdout, colorRed, "✘") + "   signature is invalid")
+			exit(1)
+		}
+
+		signedAt, trusted, err := signingTime(payload)
+		check(err)
+
+		if !trusted {
+			errorf("\n%s the time the payload was signed is taken from the payload, which is set by the signer. A compromised key can sign payloads that claim to have been signed before it was revoked, specify when the payload was received to verify against a trusted time [--at]\n", paint(os.Stderr, colorYellow, "warning:"))
+		}
+
+		fmt.Println("signed at:     ", signedAt.UTC().Format(time.RFC3339))
+		fmt.Println("")
+
+		if !k.validAt(signedAt) {
+			fmt.Println(paint(os.Stdout, colorRed, "✘") + "   signature is valid, but the key was not valid when the payload was signed")
+			exit(1)
+		}
+
+		fmt.Println(paint(os.Stdout, colorGreen, "✓") + "   signature is valid, and the key was valid when the payload was signed")
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(verifyCommand)
+	verifyCommand.Flags().StringVarP(&selfID, "self-id", "i", "", "Identity that signed the jws")
+	verifyCommand.Flags().StringVarP(&secretKey, "secret-key", "s", "", "Device secret key, used to fetch the identities history")
+	verifyCommand.Flags().StringVarP(&appID, "app-id", "a", "", "App identity to authenticate as, defaults to the identity that signed the jws")
+	verifyCommand.Flags().StringVarP(&historyFile, "history", "H", "", "File containing the identities history, instead of fetching it")
+	verifyCommand.Flags().StringVarP(&payloadFile, "payload", "p", "", "File containing the payload of a detached jws")
+	verifyCommand.Flags().StringVarP(&atTime, "at", "t", "", "Time the payload was signed or received, instead of the time claimed by the payload [unix timestamp, RFC3339]")
+}
+
+// parseJWS parses a compact or json serialized jws, which may be provided
+// directly or as a file. If a payload file is specified, the jws is detached
+func parseJWS(input, payloadPath string) (*jose.JSONWebSignature, []byte, error) {
+	data := []byte(input)
+
+	if _, err := os.Stat(input); input == "" || input == "-" || err == nil {
+		data, err = readInput(input)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	data = []byte(strings.TrimSpace(string(data)))
+
+	if len(data) == 0 {
+		return nil, nil, errors.New("no signature provided")
+	}
+
+	var payload []byte
+
+	if payloadPath != "" {
+		var err error
+
+		payload, err = readInput(payloadPath)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if data[0] == '{' {
+			// attach the payload to the json serialized jws
+			var m map[string]interface{}
+
+			err = json.Unmarshal(data, &m)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			m["payload"] = enc.EncodeToString(payload)
+
+			data, err = json.Marshal(m)
+			if err != nil {
+				return nil, nil, err
+			}
+		} else {
+			jws, err := jose.ParseDetached(string(data), payload)
+			return jws, payload, err
+		}
+	}
+
+	jws, err := jose.ParseSigned(string(data))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(jws.Signatures) != 1 {
+		return nil, nil, errors.New("the jws must contain exactly one signature")
+	}
+
+	return jws, jws.UnsafePayloadWithoutVerification(), nil
+}
+
+// checkHistoryIdentity checks that a loaded history belongs to the identity that
+// signed the jws. Histories that are just an array of operations can't be checked
+func checkHistoryIdentity(identity *Identity, selfID string) error {
+	if identity.SelfID != "" && identity.SelfID != selfID {
+		return fmt.Errorf("the history is for identity %s, not %s", identity.SelfID, selfID)
+	}
+
+	return nil
+}
+
+// signingTime returns the time a payload was signed, as specified by the '--at'
+// flag, or as claimed by the payload. A time claimed by the payload is set by
+// the signer, so is not trusted
+func signingTime(payload []byte) (time.Time, bool, error) {
+	if atTime != "" {
+		at, err := parseTime(atTime)
+		return at, true, err
+	}
+
+	at, err := payloadTime(payload)
+
+	return at, false, err
+}
+
+// payloadTime gets the time a payload was signed from its 'iat' or 'timestamp'
+// field, which may be either a unix timestamp or RFC3339 formatted
+func payloadTime(payload []byte) (time.Time, error) {
+	var claims map[string]interface{}
+
+	err := json.Unmarshal(payload, &claims)
+	if err != nil {
+		return time.Time{}, errors.New("the payload does not specify when it was signed, you must specify a time [--at]")
+	}
+
+	for _, field := range []string{"iat", "timestamp"} {
+		switch v := claims[field].(type) {
+		case float64:
+			return timestamp(int64(v)), nil
+		case string:
+			return parseTime(v)
+		}
+	}
+
+	return time.Time{}, errors.New("the payload does not specify when it was signed, you must specify a time [--at]")
+}
+
+// formatTime formats a unix timestamp, or '-' if it is not set
+func formatTime(ts int64) string {
+	if ts == 0 {
+		return "-"
+	}
+
+	return time.Unix(ts, 0).UTC().Format(time.RFC3339)
+}
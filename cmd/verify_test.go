@@ -0,0 +1,177 @@
+// Copyright 2020 Self Group Ltd. All Rights Reserved.
+
+package cmd
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseJWS(t *testing.T) {
+	sk := generateKey(keyTypeSecret, "1")
+	payload := []byte(`{"iat":1600000000}`)
+
+	jws, err := signJWS(sk, payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	compact, err := jws.CompactSerialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	detached, err := jws.DetachedCompactSerialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+
+		err := os.WriteFile(path, []byte(content), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return path
+	}
+
+	payloadFile := write("payload.json", string(payload))
+
+	tests := []struct {
+		name    string
+		input   string
+		payload string
+		err     bool
+	}{
+		{"compact", compact, "", false},
+		{"json", jws.FullSerialize(), "", false},
+		{"file", write("jws", compact+"\n"), "", false},
+		{"detached compact", detached, payloadFile, false},
+		{"empty file", write("empty", ""), "", true},
+		{"empty file with payload", write("blank", "  \n"), payloadFile, true},
+		{"invalid", "not-a-jws", "", true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			parsed, p, err := parseJWS(tc.input, tc.payload)
+			if tc.err {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if string(p) != string(payload) {
+				t.Fatalf("expected payload %s, got %s", payload, p)
+			}
+
+			_, err = parsed.Verify(sk.publicKey())
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestSigningTime(t *testing.T) {
+	tests := []struct {
+		name     string
+		at       string
+		payload  string
+		expected int64
+		trusted  bool
+		err      bool
+	}{
+		{"iat", "", `{"iat":1600000000}`, 1600000000, false, false},
+		{"timestamp", "", `{"timestamp":"2020-09-13T12:26:40Z"}`, 1600000000, false, false},
+		{"at overrides payload", "1500000000", `{"iat":1600000000}`, 1500000000, true, false},
+		{"at without payload time", "2020-09-13T12:26:40Z", `{"id":"app"}`, 1600000000, true, false},
+		{"no time", "", `{"id":"app"}`, 0, false, true},
+		{"not json", "", "payload", 0, false, true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			previous := atTime
+			defer func() { atTime = previous }()
+
+			atTime = tc.at
+
+			signedAt, trusted, err := signingTime([]byte(tc.payload))
+			if tc.err {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if signedAt.Unix() != tc.expected || trusted != tc.trusted {
+				t.Fatalf("expected %d trusted %t, got %d trusted %t", tc.expected, tc.trusted, signedAt.Unix(), trusted)
+			}
+		})
+	}
+}
+
+func TestCheckHistoryIdentity(t *testing.T) {
+	ti := newTestIdentity(t, "app", time.Now().Add(-time.Hour), "1")
+
+	identity, err := json.Marshal(Identity{SelfID: ti.selfID, Type: "app", History: ti.history})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	operations, err := json.Marshal(ti.history)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+
+	tests := []struct {
+		name    string
+		history []byte
+		selfID  string
+		err     bool
+	}{
+		{"identity", identity, "app", false},
+		{"another identity", identity, "other", true},
+		{"operations", operations, "other", false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(dir, "history.json")
+
+			err := os.WriteFile(path, tc.history, 0600)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			loaded, _, err := loadIdentity(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = checkHistoryIdentity(loaded, tc.selfID)
+			if tc.err != (err != nil) {
+				t.Fatalf("expected error %t, got %v", tc.err, err)
+			}
+		})
+	}
+}